@@ -37,3 +37,18 @@ func JWTMiddleware(jwtMaker token.Maker) echo.MiddlewareFunc {
 		}
 	}
 }
+
+// QueryTokenMiddleware lets a route take its token from the access_token query param, for browsers that cannot
+// set headers on an EventSource. Tokens in URLs end up in logs, so only streams should use it, ahead of
+// JWTMiddleware.
+func QueryTokenMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			request := c.Request()
+			if request.Header.Get("Authorization") == "" && c.QueryParam("access_token") != "" {
+				request.Header.Set("Authorization", "Bearer "+c.QueryParam("access_token"))
+			}
+			return next(c)
+		}
+	}
+}
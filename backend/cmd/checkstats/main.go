@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"gandalf-data-aggregator/config"
+	"gandalf-data-aggregator/postgres"
+	"gandalf-data-aggregator/repository"
+	"gandalf-data-aggregator/service"
+	"gandalf-data-aggregator/store"
+	workertask "gandalf-data-aggregator/worker/tasks"
+	"os"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/rs/zerolog/log"
+)
+
+// checkstats prints a JSON report of stats that drifted from the activities they count and of activities stuck
+// unprocessed. It exits with status 1 while any problem is left unrepaired, so it can back an alert.
+func main() {
+	fix := flag.Bool("fix", false, "rebuild the stats of every affected user")
+	stuckAfter := flag.Duration("stuck-after", time.Hour, "how long an activity may stay unprocessed before it is reported")
+	flag.Parse()
+
+	var cfg config.Config
+
+	err := cleanenv.ReadEnv(&cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("clean env failed to read env variables")
+	}
+
+	db, err := postgres.NewPostgresConnection(cfg.Database.URL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to start postgres connection")
+	}
+
+	service := service.NewService(cfg, repository.NewPostgres(db), nil, store.NewSessionStore(), workertask.WorkerTask{}, nil, nil, nil)
+
+	report, err := service.CheckStatsConsistency(context.Background(), *stuckAfter, *fix)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to check stats consistency")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatal().Err(err).Msg("unable to encode report")
+	}
+
+	problems := len(report.Discrepancies) + len(report.Stuck)
+	if problems > 0 && (!*fix || len(report.Failed) > 0) {
+		os.Exit(1)
+	}
+}
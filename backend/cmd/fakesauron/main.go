@@ -0,0 +1,42 @@
+package main
+
+import (
+	"gandalf-data-aggregator/webapi/fakesauron"
+	"net/http"
+	"strings"
+
+	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/rs/zerolog/log"
+)
+
+type fakeConfig struct {
+	Addr      string `env:"FAKE_SAURON_ADDR" env-default:":1000"`
+	PublicKey string `env-required:"true" env:"GANDALF_APP_PUBLIC_KEY"`
+	DataKeys  string `env:"FAKE_SAURON_DATA_KEYS"`
+}
+
+func main() {
+	var cfg fakeConfig
+
+	err := cleanenv.ReadEnv(&cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("clean env failed to read env variables")
+	}
+
+	srv, err := fakesauron.New(cfg.PublicKey)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to instantiate fake sauron")
+	}
+
+	if cfg.DataKeys != "" {
+		srv.AllowDataKeys(strings.Split(cfg.DataKeys, ",")...)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/public/gql", srv)
+
+	log.Info().Msgf("Starting fake sauron on %s/public/gql", cfg.Addr)
+	if err := http.ListenAndServe(cfg.Addr, mux); err != nil {
+		log.Fatal().Err(err).Msg("fake sauron failed to run")
+	}
+}
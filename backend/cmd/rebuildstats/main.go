@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"gandalf-data-aggregator/config"
+	"gandalf-data-aggregator/pkg/crypto"
+	"gandalf-data-aggregator/postgres"
+	"gandalf-data-aggregator/repository"
+	"gandalf-data-aggregator/service"
+	"gandalf-data-aggregator/store"
+	workertask "gandalf-data-aggregator/worker/tasks"
+
+	"github.com/google/uuid"
+	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/rs/zerolog/log"
+)
+
+// rebuildstats recomputes activity stats from the activities table, for one user with -user or for every
+// user otherwise. With -enqueue the rebuilds are queued for the worker instead of run here.
+func main() {
+	userFlag := flag.String("user", "", "id of the user to rebuild, every user when empty")
+	enqueue := flag.Bool("enqueue", false, "queue the rebuilds on the worker instead of running them")
+	flag.Parse()
+
+	var cfg config.Config
+
+	err := cleanenv.ReadEnv(&cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("clean env failed to read env variables")
+	}
+
+	db, err := postgres.NewPostgresConnection(cfg.Database.URL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to start postgres connection")
+	}
+
+	envelope, err := crypto.NewEnvelope(cfg.DataKeyEncryption.Key, cfg.DataKeyEncryption.PreviousKeys...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to load data key encryption keys")
+	}
+
+	workerTask := workertask.NewWorkerTask(cfg)
+	service := service.NewService(cfg, repository.NewPostgres(db), nil, store.NewSessionStore(), workerTask, nil, nil, envelope)
+
+	ctx := context.Background()
+	var userIDs []uuid.UUID
+	if *userFlag != "" {
+		userID, err := uuid.Parse(*userFlag)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid user id")
+		}
+		userIDs = append(userIDs, userID)
+	} else {
+		userIDs, err = service.GetUserIDsWithActivities(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to list users")
+		}
+	}
+
+	failed := 0
+	for _, userID := range userIDs {
+		if *enqueue {
+			if err := service.EnqueueRebuildActivityStats(userID); err != nil {
+				log.Error().Err(err).Msgf("Unable to enqueue stats rebuild for user %s", userID)
+				failed++
+			}
+			continue
+		}
+
+		counted, err := service.RebuildActivityStats(ctx, userID)
+		if err != nil {
+			log.Error().Err(err).Msgf("Unable to rebuild stats for user %s", userID)
+			failed++
+			continue
+		}
+		log.Info().Msgf("Rebuilt stats of user %s from %d activities", userID, counted)
+	}
+
+	if failed > 0 {
+		log.Fatal().Msgf("%d of %d stats rebuilds failed", failed, len(userIDs))
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"gandalf-data-aggregator/config"
+	"gandalf-data-aggregator/pkg/crypto"
+	"gandalf-data-aggregator/postgres"
+	"gandalf-data-aggregator/repository"
+	"gandalf-data-aggregator/service"
+	"gandalf-data-aggregator/store"
+	workertask "gandalf-data-aggregator/worker/tasks"
+
+	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/rs/zerolog/log"
+)
+
+// rotatekeys re-encrypts every stored data key under DATA_KEY_ENCRYPTION_KEY. To rotate, move the old key to
+// DATA_KEY_PREVIOUS_ENCRYPTION_KEYS, set the new one, run this, then drop the old key once it reports no errors.
+func main() {
+	var cfg config.Config
+
+	err := cleanenv.ReadEnv(&cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("clean env failed to read env variables")
+	}
+
+	db, err := postgres.NewPostgresConnection(cfg.Database.URL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to start postgres connection")
+	}
+
+	envelope, err := crypto.NewEnvelope(cfg.DataKeyEncryption.Key, cfg.DataKeyEncryption.PreviousKeys...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to load data key encryption keys")
+	}
+
+	service := service.NewService(cfg, repository.NewPostgres(db), nil, store.NewSessionStore(), workertask.WorkerTask{}, nil, nil, envelope)
+
+	rotated, err := service.RotateDataKeyEncryption(context.Background(), 100)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("data key rotation stopped after %d keys", rotated)
+	}
+
+	log.Info().Msgf("Rotated %d data keys", rotated)
+}
@@ -4,14 +4,16 @@ import (
 	"fmt"
 	"gandalf-data-aggregator/config"
 	"gandalf-data-aggregator/delivery"
-	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/pkg/crypto"
 	token "gandalf-data-aggregator/pkg/jwt"
 	"gandalf-data-aggregator/postgres"
+	"gandalf-data-aggregator/progress"
 	"gandalf-data-aggregator/repository"
 	"gandalf-data-aggregator/service"
 	"gandalf-data-aggregator/store"
 	"gandalf-data-aggregator/webapi/eyeofsauron"
 	workertask "gandalf-data-aggregator/worker/tasks"
+	"net/http"
 
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/labstack/echo/v4"
@@ -31,22 +33,54 @@ func main() {
 		log.Fatal().Err(err).Msg("unable to start postgres connection")
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.Activity{}, &models.Identifier{}, &models.DataKey{}, &models.ActivityStat{})
+	err = postgres.Migrate(db)
 	if err != nil {
-		log.Fatal().Err(err).Msg("unable to auto migrate database")
+		log.Fatal().Err(err).Msg("unable to migrate database")
 	}
 
 	jwtMaker, err := token.NewJWTMaker(cfg.JWTSecretKey)
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to initialize jwt maker")
 	}
+	// data keys are verified with calls signed by the private key, a mismatched pair would reject every callback
+	appPrivateKey, err := crypto.HexToECDSAPrivateKey(cfg.Gandalf.PrivateKey)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to parse gandalf app private key")
+	}
+	appPublicKey, err := crypto.HexToECDSAPublicKey(cfg.Gandalf.PublicKey)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to parse gandalf app public key")
+	}
+	if !appPrivateKey.PublicKey.Equal(appPublicKey) {
+		log.Fatal().Msg("GANDALF_APP_PUBLIC_KEY does not match GANDALF_APP_PRIVATE_KEY")
+	}
+
+	envelope, err := crypto.NewEnvelope(cfg.DataKeyEncryption.Key, cfg.DataKeyEncryption.PreviousKeys...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to load data key encryption keys")
+	}
 	workerTask := workertask.NewWorkerTask(cfg)
-	eyeOfSauron, err := eyeofsauron.NewEyeOfSauron(cfg.Gandalf.PrivateKey)
+	broker, err := progress.NewRedisBroker(cfg.Redis.URL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to create progress broker")
+	}
+	eyeOfSauron, err := eyeofsauron.New(
+		cfg.Gandalf.SauronURL,
+		cfg.Gandalf.PrivateKey,
+		eyeofsauron.WithHTTPClient(&http.Client{Timeout: cfg.Gandalf.Timeout}),
+	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to instiate eye of sauron")
 	}
+	gandalfClient := eyeofsauron.NewRateLimitedClient(eyeOfSauron, eyeofsauron.RateLimit{
+		RequestsPerSecond: cfg.Gandalf.RequestsPerSecond,
+		Burst:             cfg.Gandalf.Burst,
+		MaxRetries:        cfg.Gandalf.MaxRetries,
+		BaseDelay:         cfg.Gandalf.RetryBaseDelay,
+		MaxDelay:          cfg.Gandalf.RetryMaxDelay,
+	})
 
-	service := service.NewService(cfg, repository.NewPostgres(db), eyeOfSauron, store.NewSessionStore(), workerTask, jwtMaker)
+	service := service.NewService(cfg, repository.NewPostgres(db), gandalfClient, store.NewSessionStore(), workerTask, jwtMaker, broker, envelope)
 
 	router := echo.New()
 
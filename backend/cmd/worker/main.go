@@ -2,8 +2,9 @@ package main
 
 import (
 	"gandalf-data-aggregator/config"
-	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/pkg/crypto"
 	"gandalf-data-aggregator/postgres"
+	"gandalf-data-aggregator/progress"
 	"gandalf-data-aggregator/repository"
 	"gandalf-data-aggregator/service"
 	"gandalf-data-aggregator/store"
@@ -11,6 +12,7 @@ import (
 	"gandalf-data-aggregator/worker/handler"
 	workertask "gandalf-data-aggregator/worker/tasks"
 	"gandalf-data-aggregator/workerqueue"
+	"net/http"
 
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/rs/zerolog/log"
@@ -30,23 +32,49 @@ func main() {
 		log.Fatal().Err(err).Msg("unable to start postgres connection")
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.Activity{}, &models.Identifier{}, &models.DataKey{}, &models.ActivityStat{})
+	err = postgres.Migrate(db)
 	if err != nil {
-		log.Fatal().Err(err).Msg("unable to auto migrate database")
+		log.Fatal().Err(err).Msg("unable to migrate database")
 	}
 
+	envelope, err := crypto.NewEnvelope(cfg.DataKeyEncryption.Key, cfg.DataKeyEncryption.PreviousKeys...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to load data key encryption keys")
+	}
 	workerTask := workertask.NewWorkerTask(cfg)
-	eyeOfSauron, err := eyeofsauron.NewEyeOfSauron(cfg.Gandalf.PrivateKey)
+	broker, err := progress.NewRedisBroker(cfg.Redis.URL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to create progress broker")
+	}
+	eyeOfSauron, err := eyeofsauron.New(
+		cfg.Gandalf.SauronURL,
+		cfg.Gandalf.PrivateKey,
+		eyeofsauron.WithHTTPClient(&http.Client{Timeout: cfg.Gandalf.Timeout}),
+	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to instiate eye of sauron")
 	}
-	service := service.NewService(cfg, repository.NewPostgres(db), eyeOfSauron, store.NewSessionStore(), workerTask, nil)
+	gandalfClient := eyeofsauron.NewRateLimitedClient(eyeOfSauron, eyeofsauron.RateLimit{
+		RequestsPerSecond: cfg.Gandalf.RequestsPerSecond,
+		Burst:             cfg.Gandalf.Burst,
+		MaxRetries:        cfg.Gandalf.MaxRetries,
+		BaseDelay:         cfg.Gandalf.RetryBaseDelay,
+		MaxDelay:          cfg.Gandalf.RetryMaxDelay,
+	})
+	service := service.NewService(cfg, repository.NewPostgres(db), gandalfClient, store.NewSessionStore(), workerTask, nil, broker, envelope)
 
 	srv := workerqueue.NewAsyncqServer(cfg)
 
 	jobHandler := handler.NewJobHandler(service, cfg, workerTask)
 	srv.AsynqSrvMux.HandleFunc(workertask.TypeActivityDataResolver, jobHandler.ResolveUserActivityData)
 	srv.AsynqSrvMux.HandleFunc(workertask.TypeGenerateActivityStats, jobHandler.GenerateActivityStats)
+	srv.AsynqSrvMux.HandleFunc(workertask.TypeTraitsResolver, jobHandler.ResolveUserTraits)
+	srv.AsynqSrvMux.HandleFunc(workertask.TypeResyncStaleDataKeys, jobHandler.ResyncStaleDataKeys)
+	srv.AsynqSrvMux.HandleFunc(workertask.TypeRebuildActivityStats, jobHandler.RebuildActivityStats)
+
+	if err := srv.RegisterPeriodicTask(cfg.Sync.Schedule, workertask.NewResyncStaleDataKeysTask()); err != nil {
+		log.Fatal().Err(err).Msg("unable to register periodic resync")
+	}
 
 	if err := srv.Run(); err != nil {
 		log.Fatal().Err(err).Msgf("could not run server: %v", err)
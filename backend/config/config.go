@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type Config struct {
 	Port string `env:"PORT" env-default:"8080"`
 
@@ -17,9 +19,16 @@ type Config struct {
 	JWTSecretKey string `env-required:"true" env:"JWT_SECRET_KEY"`
 
 	Gandalf struct {
-		PublicKey  string `env-required:"true" env:"GANDALF_APP_PUBLIC_KEY"`
-		PrivateKey string `env-required:"true" env:"GANDALF_APP_PRIVATE_KEY"`
-		SauronURL  string `env-required:"true" env:"GANDALF_SAURON_URL"`
+		PublicKey  string        `env-required:"true" env:"GANDALF_APP_PUBLIC_KEY"`
+		PrivateKey string        `env-required:"true" env:"GANDALF_APP_PRIVATE_KEY"`
+		SauronURL  string        `env-required:"true" env:"GANDALF_SAURON_URL"`
+		Timeout    time.Duration `env:"GANDALF_TIMEOUT" env-default:"30s"`
+
+		RequestsPerSecond float64       `env:"GANDALF_REQUESTS_PER_SECOND" env-default:"2"`
+		Burst             int           `env:"GANDALF_BURST" env-default:"4"`
+		MaxRetries        int           `env:"GANDALF_MAX_RETRIES" env-default:"4"`
+		RetryBaseDelay    time.Duration `env:"GANDALF_RETRY_BASE_DELAY" env-default:"500ms"`
+		RetryMaxDelay     time.Duration `env:"GANDALF_RETRY_MAX_DELAY" env-default:"30s"`
 	}
 
 	Twitter struct {
@@ -28,7 +37,22 @@ type Config struct {
 		Callback string `env-required:"true" env:"TWITTER_CALLBACK"`
 	}
 
+	// DataKeyEncryption holds the base64 encoded 32 byte master keys that wrap stored gandalf data keys.
+	// PreviousKeys only open keys sealed before a rotation, new keys are always sealed with Key.
+	DataKeyEncryption struct {
+		Key          string   `env-required:"true" env:"DATA_KEY_ENCRYPTION_KEY"`
+		PreviousKeys []string `env:"DATA_KEY_PREVIOUS_ENCRYPTION_KEYS" env-separator:","`
+	}
+
 	Redis struct {
 		URL string `env-required:"true" env:"REDIS_URL"`
 	}
+
+	Sync struct {
+		Schedule  string        `env:"SYNC_SCHEDULE" env-default:"@every 1h"`
+		Staleness time.Duration `env:"SYNC_STALENESS" env-default:"24h"`
+		Jitter    time.Duration `env:"SYNC_JITTER" env-default:"15m"`
+		// FetchConcurrency bounds how many pages of one import are fetched at once
+		FetchConcurrency int `env:"SYNC_FETCH_CONCURRENCY" env-default:"4"`
+	}
 }
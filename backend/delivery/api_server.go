@@ -1,12 +1,19 @@
 package delivery
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"gandalf-data-aggregator/auth"
 	"gandalf-data-aggregator/config"
+	"gandalf-data-aggregator/models"
 	token "gandalf-data-aggregator/pkg/jwt"
 	"gandalf-data-aggregator/service"
+	"html/template"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
@@ -16,6 +23,16 @@ import (
 
 type ServerInterface interface {
 	UserActivity(ctx echo.Context) error
+	UserTripStats(ctx echo.Context) error
+	UserOrderStats(ctx echo.Context) error
+	UserActivityHeatmap(ctx echo.Context) error
+	UserTopSeries(ctx echo.Context) error
+	UserCompletedSeasons(ctx echo.Context) error
+	UserTitleSplit(ctx echo.Context) error
+	UserTraits(ctx echo.Context) error
+	UserSyncStatus(ctx echo.Context) error
+	UserSyncEvents(ctx echo.Context) error
+	UnlinkSource(ctx echo.Context) error
 	CompleteAuth(ctx echo.Context) error
 	BeginAuth(ctx echo.Context) error
 	CurrentUser(ctx echo.Context) error
@@ -56,9 +73,21 @@ func (s *Server) registerAuthHandlers() {
 
 	authGroup.Use(auth.JWTMiddleware(s.jwtMaker))
 
+	// the event stream is the only route taking its token from the query string
+	s.router.GET("/user/sync/events", s.UserSyncEvents, auth.QueryTokenMiddleware(), auth.JWTMiddleware(s.jwtMaker))
+
 	authGroup.GET("/me", s.CurrentUser)
 	authGroup.GET("/activity", s.UserActivity)
+	authGroup.GET("/activity/trips", s.UserTripStats)
+	authGroup.GET("/activity/orders", s.UserOrderStats)
+	authGroup.GET("/activity/heatmap", s.UserActivityHeatmap)
+	authGroup.GET("/activity/series", s.UserTopSeries)
+	authGroup.GET("/activity/series/seasons", s.UserCompletedSeasons)
+	authGroup.GET("/activity/series/split", s.UserTitleSplit)
+	authGroup.GET("/traits", s.UserTraits)
+	authGroup.GET("/sync-status", s.UserSyncStatus)
 	authGroup.GET("/generate-callback", s.GenerateGandalfCallback)
+	authGroup.DELETE("/sources/:source", s.UnlinkSource)
 }
 
 func (s *Server) registerUnAuthHandlers() {
@@ -80,9 +109,16 @@ func (s *Server) CurrentUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Unable to get user")
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"username":   user.Username,
-		"avatar_url": user.AvatarURL,
+	reconnectSources, err := s.service.GetSourcesNeedingReconnect(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to get user sources")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"username":          user.Username,
+		"avatar_url":        user.AvatarURL,
+		"needs_reconnect":   len(reconnectSources) > 0,
+		"reconnect_sources": reconnectSources,
 	})
 }
 
@@ -132,14 +168,44 @@ func (s *Server) RegisterUserDataKey(c echo.Context) error {
 		parsedURL.Query().Get("dataKey"),
 		c.Param("source"),
 	)
-	if err != nil {
-		log.Error().Err(err).Msg("RegisterUserDataKey failed")
-	} else {
-		log.Info().Msg(c.Request().URL.String())
+	if err == nil {
 		return c.Redirect(http.StatusFound, s.cfg.WebAppURL)
 	}
 
-	return c.JSON(http.StatusOK, nil)
+	log.Error().Err(err).Msg("RegisterUserDataKey failed")
+	switch {
+	case errors.Is(err, service.ErrInvalidDataKey):
+		return s.renderCallbackError(c, http.StatusBadRequest, "Gandalf did not accept this connection. Please connect the source again from the app.")
+	case errors.Is(err, service.ErrUnknownCallbackState):
+		return s.renderCallbackError(c, http.StatusBadRequest, "This connection link has expired. Please start connecting the source again from the app.")
+	case errors.Is(err, models.ErrUnsupportedDataType):
+		return s.renderCallbackError(c, http.StatusBadRequest, "This source is not supported.")
+	default:
+		return s.renderCallbackError(c, http.StatusBadGateway, "We could not reach Gandalf to confirm the connection. Please try again in a few minutes.")
+	}
+}
+
+var callbackErrorPage = template.Must(template.New("callback-error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unable to connect source</title></head>
+<body style="font-family: sans-serif; max-width: 32rem; margin: 4rem auto; text-align: center">
+<h1>Unable to connect source</h1>
+<p>{{.Message}}</p>
+<p><a href="{{.WebAppURL}}">Back to the app</a></p>
+</body>
+</html>`))
+
+// renderCallbackError shows the user a page instead of JSON, since the gandalf callback is opened in the browser.
+func (s *Server) renderCallbackError(c echo.Context, status int, message string) error {
+	var page bytes.Buffer
+	err := callbackErrorPage.Execute(&page, map[string]string{
+		"Message":   message,
+		"WebAppURL": s.cfg.WebAppURL,
+	})
+	if err != nil {
+		return echo.NewHTTPError(status, message)
+	}
+	return c.HTML(status, page.String())
 }
 
 func (s *Server) GenerateGandalfCallback(c echo.Context) error {
@@ -148,7 +214,18 @@ func (s *Server) GenerateGandalfCallback(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
 	}
 
-	callbackURL, err := s.service.GenerateGandalfCallback(c.Request().Context(), userID)
+	// the web app predates multiple sources and never sends one
+	source := c.QueryParam("source")
+	if source == "" {
+		source = string(models.DataTypeNetflix)
+	}
+
+	dataType, err := models.ParseDataType(source)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	callbackURL, err := s.service.GenerateGandalfCallback(c.Request().Context(), userID, dataType)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to generate callback url")
 	}
@@ -157,8 +234,10 @@ func (s *Server) GenerateGandalfCallback(c echo.Context) error {
 }
 
 type UserActivityParams struct {
-	Limit int `query:"limit"`
-	Page  int `query:"page"`
+	Limit       int    `query:"limit"`
+	Page        int    `query:"page"`
+	Source      string `query:"source"`
+	ContentType string `query:"content_type"`
 }
 
 func (s *Server) UserActivity(c echo.Context) error {
@@ -173,12 +252,20 @@ func (s *Server) UserActivity(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Invalid parameter")
 	}
 
-	activities, err := s.service.GetActivitySetByUser(c.Request().Context(), userID, params.Limit, params.Page)
+	var dataType models.DataType
+	if params.Source != "" {
+		dataType, err = models.ParseDataType(params.Source)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	activities, err := s.service.GetActivitySetByUser(c.Request().Context(), userID, dataType, params.Limit, params.Page)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch activities")
 	}
 
-	stats, err := s.service.GenerateUserYearlyData(c.Request().Context(), userID)
+	stats, err := s.service.GenerateUserYearlyData(c.Request().Context(), userID, dataType, params.ContentType)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch activities")
 	}
@@ -188,3 +275,262 @@ func (s *Server) UserActivity(c echo.Context) error {
 		"stats":      stats,
 	})
 }
+
+func (s *Server) UserTripStats(c echo.Context) error {
+	userID, ok := c.Get("UserID").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
+	}
+
+	stats, err := s.service.GenerateUserTripStats(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch trip stats")
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+type UserOrderStatsParams struct {
+	Limit int `query:"limit"`
+}
+
+func (s *Server) UserOrderStats(c echo.Context) error {
+	userID, ok := c.Get("UserID").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
+	}
+
+	var params UserOrderStatsParams
+	if err := c.Bind(&params); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid parameter")
+	}
+
+	stats, err := s.service.GenerateUserOrderStats(c.Request().Context(), userID, params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch order stats")
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+type UserActivityHeatmapParams struct {
+	Source      string `query:"source"`
+	ContentType string `query:"content_type"`
+	Year        int    `query:"year"`
+}
+
+func (s *Server) UserActivityHeatmap(c echo.Context) error {
+	userID, ok := c.Get("UserID").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
+	}
+
+	var params UserActivityHeatmapParams
+	err := c.Bind(&params)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid parameter")
+	}
+
+	var dataType models.DataType
+	if params.Source != "" {
+		dataType, err = models.ParseDataType(params.Source)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	heatmap, err := s.service.GenerateUserActivityHeatmap(c.Request().Context(), userID, dataType, params.ContentType, params.Year)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch activity heatmap")
+	}
+
+	return c.JSON(http.StatusOK, heatmap)
+}
+
+type UserTopSeriesParams struct {
+	Limit int `query:"limit"`
+}
+
+func (s *Server) UserTopSeries(c echo.Context) error {
+	userID, ok := c.Get("UserID").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
+	}
+
+	var params UserTopSeriesParams
+	err := c.Bind(&params)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid parameter")
+	}
+
+	series, err := s.service.GetUserTopSeries(c.Request().Context(), userID, params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch top series")
+	}
+
+	return c.JSON(http.StatusOK, series)
+}
+
+func (s *Server) UserCompletedSeasons(c echo.Context) error {
+	userID, ok := c.Get("UserID").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
+	}
+
+	seasons, err := s.service.GetUserCompletedSeasons(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch completed seasons")
+	}
+
+	return c.JSON(http.StatusOK, seasons)
+}
+
+func (s *Server) UserTitleSplit(c echo.Context) error {
+	userID, ok := c.Get("UserID").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
+	}
+
+	split, err := s.service.GetUserTitleSplit(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch movies and series split")
+	}
+
+	return c.JSON(http.StatusOK, split)
+}
+
+type UserTraitsParams struct {
+	Source  string `query:"source"`
+	History bool   `query:"history"`
+}
+
+func (s *Server) UserTraits(c echo.Context) error {
+	userID, ok := c.Get("UserID").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
+	}
+
+	var params UserTraitsParams
+	err := c.Bind(&params)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid parameter")
+	}
+
+	var dataType models.DataType
+	if params.Source != "" {
+		dataType, err = models.ParseDataType(params.Source)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	traits, err := s.service.GetUserTraits(c.Request().Context(), userID, dataType, params.History)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch traits")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"traits": traits,
+	})
+}
+
+func (s *Server) UserSyncStatus(c echo.Context) error {
+	userID, ok := c.Get("UserID").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
+	}
+
+	var dataType models.DataType
+	if source := c.QueryParam("source"); source != "" {
+		var err error
+		dataType, err = models.ParseDataType(source)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	status, err := s.service.GetUserSyncStatus(c.Request().Context(), userID, dataType)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch sync status")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sources": status,
+	})
+}
+
+// sseKeepAlive is how often an idle event stream is pinged so proxies do not close it.
+const sseKeepAlive = 15 * time.Second
+
+// UserSyncEvents streams the progress of the user's syncs as Server-Sent Events.
+func (s *Server) UserSyncEvents(c echo.Context) error {
+	userID, ok := c.Get("UserID").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
+	}
+
+	ctx := c.Request().Context()
+	events, err := s.service.SubscribeSyncProgress(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to subscribe to sync events")
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Error().Err(err).Msg("Unable to encode sync event")
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Phase, data); err != nil {
+				return nil
+			}
+			w.Flush()
+		}
+	}
+}
+
+func (s *Server) UnlinkSource(c echo.Context) error {
+	userID, ok := c.Get("UserID").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
+	}
+
+	dataType, err := models.ParseDataType(c.Param("source"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	purge, err := s.service.UnlinkSource(c.Request().Context(), userID, dataType)
+	if errors.Is(err, service.ErrSourceNotLinked) {
+		return echo.NewHTTPError(http.StatusNotFound, "Source is not linked")
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Unable to unlink %s", dataType)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to unlink source")
+	}
+
+	return c.JSON(http.StatusOK, purge)
+}
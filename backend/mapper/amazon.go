@@ -0,0 +1,45 @@
+package mapper
+
+import (
+	"fmt"
+	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/pkg/money"
+	"gandalf-data-aggregator/webapi/eyeofsauron"
+
+	"github.com/google/uuid"
+)
+
+type AmazonMapper struct{}
+
+func (AmazonMapper) Source() eyeofsauron.Source { return eyeofsauron.SourceAmazon }
+
+func (AmazonMapper) DataType() models.DataType { return models.DataTypeAmazon }
+
+func (m AmazonMapper) Map(userID uuid.UUID, activityID string, metadata eyeofsauron.GetActivityActivityResponseDataActivityMetadata) (*models.Activity, error) {
+	meta, ok := metadata.(*eyeofsauron.GetActivityActivityResponseDataActivityMetadataAmazonActivityMetadata)
+	if !ok {
+		return nil, unexpectedMetadata(m.Source(), metadata)
+	}
+
+	date, err := parseActivityDate(meta.Date)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse date: %w", err)
+	}
+
+	cost, err := money.Parse(meta.TotalCost)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse total cost: %w", err)
+	}
+
+	return &models.Activity{
+		UserID:             userID,
+		DataType:           m.DataType(),
+		ProviderActivityID: activityID,
+		Title:              meta.ProductName,
+		Date:               date,
+		Quantity:           meta.QuantityPurchased,
+		Amount:             cost.Value,
+		Currency:           cost.Currency,
+		Subject:            mapIdentifiers(meta.Subject),
+	}, nil
+}
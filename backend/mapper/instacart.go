@@ -0,0 +1,71 @@
+package mapper
+
+import (
+	"fmt"
+	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/pkg/money"
+	"gandalf-data-aggregator/webapi/eyeofsauron"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type InstacartMapper struct{}
+
+func (InstacartMapper) Source() eyeofsauron.Source { return eyeofsauron.SourceInstacart }
+
+func (InstacartMapper) DataType() models.DataType { return models.DataTypeInstacart }
+
+func (m InstacartMapper) Map(userID uuid.UUID, activityID string, metadata eyeofsauron.GetActivityActivityResponseDataActivityMetadata) (*models.Activity, error) {
+	meta, ok := metadata.(*eyeofsauron.GetActivityActivityResponseDataActivityMetadataInstacartActivityMetadata)
+	if !ok {
+		return nil, unexpectedMetadata(m.Source(), metadata)
+	}
+
+	orderedAt, err := parseActivityDate(meta.DateOrdered)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse order date: %w", err)
+	}
+
+	var deliveredAt *time.Time
+	if date, err := parseActivityDate(meta.DateDelivered); err == nil {
+		deliveredAt = &date
+	}
+
+	total, err := money.Parse(meta.TotalOrderAmountSpent)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse order total: %w", err)
+	}
+
+	var items []models.OrderItem
+	for _, item := range meta.Items {
+		unitPrice, err := money.Parse(item.UnitPrice)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse unit price of item %s: %w", item.ItemID, err)
+		}
+
+		items = append(items, models.OrderItem{
+			ItemID:      item.ItemID,
+			ProductName: item.ProductName,
+			UnitPrice:   unitPrice.Value,
+			Currency:    unitPrice.Currency,
+			Status:      string(item.Status),
+			Quantity:    int64(item.QuantityPurchased),
+		})
+	}
+
+	return &models.Activity{
+		UserID:             userID,
+		DataType:           m.DataType(),
+		ProviderActivityID: activityID,
+		Title:              meta.Retailer,
+		Date:               orderedAt,
+		EndedAt:            deliveredAt,
+		Amount:             total.Value,
+		Currency:           total.Currency,
+		Status:             meta.StatusString,
+		Quantity:           len(items),
+		Items:              items,
+		Subject:            mapIdentifiers(meta.Subject),
+	}, nil
+}
@@ -0,0 +1,154 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/webapi/eyeofsauron"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gandalf-network/gandalf-sdk-go/eyeofsauron/graphqlTypes"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnsupportedSource  = errors.New("no mapper registered for source")
+	ErrUnexpectedMetadata = errors.New("unexpected metadata for source")
+)
+
+// SourceMapper converts the gandalf activity metadata of a single source into domain models.
+type SourceMapper interface {
+	// Source is the gandalf source the mapper is registered under
+	Source() eyeofsauron.Source
+
+	// DataType is the data type users link the source as
+	DataType() models.DataType
+
+	// Map converts a single activity, returning ErrUnexpectedMetadata for metadata of another source
+	Map(userID uuid.UUID, activityID string, metadata eyeofsauron.GetActivityActivityResponseDataActivityMetadata) (*models.Activity, error)
+}
+
+// Registry looks mappers up by their gandalf source.
+type Registry struct {
+	mappers map[eyeofsauron.Source]SourceMapper
+	mutex   sync.RWMutex
+}
+
+func NewRegistry(mappers ...SourceMapper) *Registry {
+	registry := &Registry{
+		mappers: make(map[eyeofsauron.Source]SourceMapper),
+	}
+	for _, mapper := range mappers {
+		registry.Register(mapper)
+	}
+	return registry
+}
+
+// NewDefaultRegistry returns a registry holding a mapper for every source we ingest.
+func NewDefaultRegistry() *Registry {
+	return NewRegistry(
+		NetflixMapper{},
+		PlaystationMapper{},
+		YoutubeMapper{},
+		AmazonMapper{},
+		UberMapper{},
+		InstacartMapper{},
+	)
+}
+
+// Register adds a mapper, replacing any mapper already registered for its source.
+func (r *Registry) Register(mapper SourceMapper) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.mappers[mapper.Source()] = mapper
+}
+
+func (r *Registry) Lookup(source eyeofsauron.Source) (SourceMapper, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	mapper, ok := r.mappers[source]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSource, source)
+	}
+	return mapper, nil
+}
+
+func (r *Registry) LookupDataType(dataType models.DataType) (SourceMapper, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, mapper := range r.mappers {
+		if mapper.DataType() == dataType {
+			return mapper, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedSource, dataType)
+}
+
+type identifier interface {
+	GetValue() string
+	GetIdentifierType() eyeofsauron.IdentifierType
+}
+
+func mapIdentifiers[T any, PT interface {
+	*T
+	identifier
+}](subject []T) []models.Identifier {
+	var identifiers []models.Identifier
+	for i := range subject {
+		var id PT = &subject[i]
+		identifiers = append(identifiers, models.Identifier{
+			Value:          id.GetValue(),
+			IdentifierType: string(id.GetIdentifierType()),
+		})
+	}
+	return identifiers
+}
+
+// activityDateLayouts are the formats gandalf has been seen to return for activity dates.
+var activityDateLayouts = []string{"01/02/2006", time.RFC3339, "2006-01-02"}
+
+func parseActivityDate(value graphqlTypes.Date) (time.Time, error) {
+	var err error
+	for _, layout := range activityDateLayouts {
+		var date time.Time
+		date, err = time.Parse(layout, string(value))
+		if err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+const kilometersPerMile = 1.609344
+
+// parseDistance reads gandalf trip distances such as "3.2", "3.2 miles" or "5.1 km" and returns kilometers.
+func parseDistance(value string) (float64, error) {
+	fields := strings.Fields(strings.ToLower(value))
+	if len(fields) == 0 {
+		return 0, nil
+	}
+
+	distance, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid distance %q: %w", value, err)
+	}
+
+	if len(fields) > 1 && strings.HasPrefix(fields[1], "mi") {
+		distance *= kilometersPerMile
+	}
+	return distance, nil
+}
+
+func unexpectedMetadata(source eyeofsauron.Source, metadata eyeofsauron.GetActivityActivityResponseDataActivityMetadata) error {
+	typename := "<nil>"
+	if metadata != nil {
+		typename = metadata.GetTypename()
+	}
+	return fmt.Errorf("%w %s: %s", ErrUnexpectedMetadata, source, typename)
+}
@@ -0,0 +1,36 @@
+package mapper
+
+import (
+	"fmt"
+	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/webapi/eyeofsauron"
+
+	"github.com/google/uuid"
+)
+
+type NetflixMapper struct{}
+
+func (NetflixMapper) Source() eyeofsauron.Source { return eyeofsauron.SourceNetflix }
+
+func (NetflixMapper) DataType() models.DataType { return models.DataTypeNetflix }
+
+func (m NetflixMapper) Map(userID uuid.UUID, activityID string, metadata eyeofsauron.GetActivityActivityResponseDataActivityMetadata) (*models.Activity, error) {
+	meta, ok := metadata.(*eyeofsauron.GetActivityActivityResponseDataActivityMetadataNetflixActivityMetadata)
+	if !ok {
+		return nil, unexpectedMetadata(m.Source(), metadata)
+	}
+
+	date, err := parseActivityDate(meta.Date)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse date: %w", err)
+	}
+
+	return &models.Activity{
+		UserID:             userID,
+		DataType:           m.DataType(),
+		ProviderActivityID: activityID,
+		Title:              meta.Title,
+		Date:               date,
+		Subject:            mapIdentifiers(meta.Subject),
+	}, nil
+}
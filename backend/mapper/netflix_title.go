@@ -0,0 +1,101 @@
+package mapper
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type TitleKind string
+
+const (
+	TitleKindMovie   TitleKind = "movie"
+	TitleKindEpisode TitleKind = "episode"
+	// TitleKindUnparsed is a title with a subtitle the parser cannot place, such as "Show: Name", which
+	// netflix uses for both single season shows and movie sequels
+	TitleKindUnparsed TitleKind = "unparsed"
+)
+
+// NetflixTitle is a netflix viewing title split into its series, season and episode.
+type NetflixTitle struct {
+	Kind   TitleKind
+	Series string
+	Season string
+	// SeasonNumber is 0 when the title names an episode without a season
+	SeasonNumber int
+	Episode      string
+}
+
+var (
+	seasonPattern        = regexp.MustCompile(`(?i)^(?:season|series|part|volume|vol\.|book|chapter|collection)\s+([0-9]+|[a-z]+)$`)
+	limitedSeriesPattern = regexp.MustCompile(`(?i)^(?:limited series|miniseries|mini-series)$`)
+	episodePattern       = regexp.MustCompile(`(?i)^(?:episode|ep\.?)\s*[0-9]+`)
+)
+
+var numberWords = map[string]int{
+	"one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10,
+	"eleven": 11, "twelve": 12, "thirteen": 13, "fourteen": 14, "fifteen": 15, "sixteen": 16, "seventeen": 17,
+	"eighteen": 18, "nineteen": 19, "twenty": 20,
+	"i": 1, "ii": 2, "iii": 3, "iv": 4, "v": 5, "vi": 6, "vii": 7, "viii": 8, "ix": 9, "x": 10,
+}
+
+// seasonNumber reads a season segment such as "Season 2", "Part Three" or "Limited Series".
+func seasonNumber(segment string) (int, bool) {
+	if limitedSeriesPattern.MatchString(segment) {
+		return 1, true
+	}
+
+	match := seasonPattern.FindStringSubmatch(segment)
+	if match == nil {
+		return 0, false
+	}
+
+	if number, err := strconv.Atoi(match[1]); err == nil {
+		return number, true
+	}
+	number, ok := numberWords[strings.ToLower(match[1])]
+	return number, ok
+}
+
+// ParseNetflixTitle splits titles such as "Show: Season 2: Episode Name". Titles without a subtitle are movies,
+// titles it cannot place are returned as TitleKindUnparsed with the raw title as the episode.
+func ParseNetflixTitle(title string) NetflixTitle {
+	title = strings.TrimSpace(title)
+
+	// only split on ": " so times and ratios like "10:30" stay intact
+	var segments []string
+	for _, segment := range strings.Split(title, ": ") {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	unparsed := NetflixTitle{Kind: TitleKindUnparsed, Episode: title}
+	switch len(segments) {
+	case 0:
+		return unparsed
+	case 1:
+		return NetflixTitle{Kind: TitleKindMovie, Episode: title}
+	case 2:
+		if episodePattern.MatchString(segments[1]) {
+			return NetflixTitle{Kind: TitleKindEpisode, Series: segments[0], Episode: segments[1]}
+		}
+		return unparsed
+	}
+
+	// the season marker is usually the second segment, but series names can contain ": " themselves
+	for i := 1; i < len(segments)-1; i++ {
+		number, ok := seasonNumber(segments[i])
+		if !ok {
+			continue
+		}
+		return NetflixTitle{
+			Kind:         TitleKindEpisode,
+			Series:       strings.Join(segments[:i], ": "),
+			Season:       segments[i],
+			SeasonNumber: number,
+			Episode:      strings.Join(segments[i+1:], ": "),
+		}
+	}
+	return unparsed
+}
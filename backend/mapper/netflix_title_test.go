@@ -0,0 +1,40 @@
+package mapper
+
+import "testing"
+
+func TestParseNetflixTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  NetflixTitle
+	}{
+		// season words and numerals
+		{"Stranger Things: Season 4: Chapter One: The Hellfire Club", NetflixTitle{Kind: TitleKindEpisode, Series: "Stranger Things", Season: "Season 4", SeasonNumber: 4, Episode: "Chapter One: The Hellfire Club"}},
+		{"Money Heist: Part Three: Episode 2", NetflixTitle{Kind: TitleKindEpisode, Series: "Money Heist", Season: "Part Three", SeasonNumber: 3, Episode: "Episode 2"}},
+		{"Rome: Season II: Stealing from Saturn", NetflixTitle{Kind: TitleKindEpisode, Series: "Rome", Season: "Season II", SeasonNumber: 2, Episode: "Stealing from Saturn"}},
+		{"Avatar: Book Three: Sozin's Comet", NetflixTitle{Kind: TitleKindEpisode, Series: "Avatar", Season: "Book Three", SeasonNumber: 3, Episode: "Sozin's Comet"}},
+		{"The Queen's Gambit: Limited Series: Openings", NetflixTitle{Kind: TitleKindEpisode, Series: "The Queen's Gambit", Season: "Limited Series", SeasonNumber: 1, Episode: "Openings"}},
+		// series names containing ": "
+		{"Star Wars: The Clone Wars: Season 7: Victory and Death", NetflixTitle{Kind: TitleKindEpisode, Series: "Star Wars: The Clone Wars", Season: "Season 7", SeasonNumber: 7, Episode: "Victory and Death"}},
+		// two segment titles naming the episode
+		{"Chernobyl: Episode 3", NetflixTitle{Kind: TitleKindEpisode, Series: "Chernobyl", Episode: "Episode 3"}},
+		{"Dark Tourist: Ep. 4", NetflixTitle{Kind: TitleKindEpisode, Series: "Dark Tourist", Episode: "Ep. 4"}},
+		// movies
+		{"The Irishman", NetflixTitle{Kind: TitleKindMovie, Episode: "The Irishman"}},
+		{"  Roma  ", NetflixTitle{Kind: TitleKindMovie, Episode: "Roma"}},
+		// times and ratios are not segments
+		{"Night Train 10:30", NetflixTitle{Kind: TitleKindMovie, Episode: "Night Train 10:30"}},
+		{"Lupin: Part 1: 10:30 at the Louvre", NetflixTitle{Kind: TitleKindEpisode, Series: "Lupin", Season: "Part 1", SeasonNumber: 1, Episode: "10:30 at the Louvre"}},
+		// titles the parser cannot place
+		{"Glass Onion: A Knives Out Mystery", NetflixTitle{Kind: TitleKindUnparsed, Episode: "Glass Onion: A Knives Out Mystery"}},
+		{"Avatar: The Last Airbender: The Boy in the Iceberg", NetflixTitle{Kind: TitleKindUnparsed, Episode: "Avatar: The Last Airbender: The Boy in the Iceberg"}},
+		{"The Simpsons: Season Thirty: Bart's Not Dead", NetflixTitle{Kind: TitleKindUnparsed, Episode: "The Simpsons: Season Thirty: Bart's Not Dead"}},
+		{"Show: Season 2", NetflixTitle{Kind: TitleKindUnparsed, Episode: "Show: Season 2"}},
+		{"", NetflixTitle{Kind: TitleKindUnparsed}},
+	}
+
+	for _, test := range tests {
+		if got := ParseNetflixTitle(test.title); got != test.want {
+			t.Errorf("ParseNetflixTitle(%q) = %+v, want %+v", test.title, got, test.want)
+		}
+	}
+}
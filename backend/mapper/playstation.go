@@ -0,0 +1,36 @@
+package mapper
+
+import (
+	"fmt"
+	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/webapi/eyeofsauron"
+
+	"github.com/google/uuid"
+)
+
+type PlaystationMapper struct{}
+
+func (PlaystationMapper) Source() eyeofsauron.Source { return eyeofsauron.SourcePlaystation }
+
+func (PlaystationMapper) DataType() models.DataType { return models.DataTypePlaystation }
+
+func (m PlaystationMapper) Map(userID uuid.UUID, activityID string, metadata eyeofsauron.GetActivityActivityResponseDataActivityMetadata) (*models.Activity, error) {
+	meta, ok := metadata.(*eyeofsauron.GetActivityActivityResponseDataActivityMetadataPlaystationActivityMetadata)
+	if !ok {
+		return nil, unexpectedMetadata(m.Source(), metadata)
+	}
+
+	date, err := parseActivityDate(meta.LastPlayedAt)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse last played date: %w", err)
+	}
+
+	return &models.Activity{
+		UserID:             userID,
+		DataType:           m.DataType(),
+		ProviderActivityID: activityID,
+		Title:              meta.Title,
+		Date:               date,
+		Subject:            mapIdentifiers(meta.Subject),
+	}, nil
+}
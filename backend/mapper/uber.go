@@ -0,0 +1,60 @@
+package mapper
+
+import (
+	"fmt"
+	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/pkg/money"
+	"gandalf-data-aggregator/webapi/eyeofsauron"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UberMapper struct{}
+
+func (UberMapper) Source() eyeofsauron.Source { return eyeofsauron.SourceUber }
+
+func (UberMapper) DataType() models.DataType { return models.DataTypeUber }
+
+func (m UberMapper) Map(userID uuid.UUID, activityID string, metadata eyeofsauron.GetActivityActivityResponseDataActivityMetadata) (*models.Activity, error) {
+	meta, ok := metadata.(*eyeofsauron.GetActivityActivityResponseDataActivityMetadataUberActivityMetadata)
+	if !ok {
+		return nil, unexpectedMetadata(m.Source(), metadata)
+	}
+
+	// cancelled trips usually come without a cost, they are stored as free rather than dropped
+	var cost money.Amount
+	if strings.TrimSpace(meta.Cost) != "" {
+		var err error
+		cost, err = money.Parse(meta.Cost)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse trip cost: %w", err)
+		}
+	}
+
+	distance, err := parseDistance(meta.Distance)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse trip distance: %w", err)
+	}
+
+	var endedAt *time.Time
+	if !meta.DropoffTime.IsZero() {
+		endedAt = &meta.DropoffTime
+	}
+
+	return &models.Activity{
+		UserID:             userID,
+		DataType:           m.DataType(),
+		ProviderActivityID: activityID,
+		Title:              meta.City,
+		Date:               meta.BeginTripTime,
+		EndedAt:            endedAt,
+		Amount:             cost.Value,
+		Currency:           cost.Currency,
+		City:               meta.City,
+		Distance:           distance,
+		Status:             string(meta.Status),
+		Subject:            mapIdentifiers(meta.Subject),
+	}, nil
+}
@@ -0,0 +1,38 @@
+package mapper
+
+import (
+	"fmt"
+	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/webapi/eyeofsauron"
+
+	"github.com/google/uuid"
+)
+
+type YoutubeMapper struct{}
+
+func (YoutubeMapper) Source() eyeofsauron.Source { return eyeofsauron.SourceYoutube }
+
+func (YoutubeMapper) DataType() models.DataType { return models.DataTypeYoutube }
+
+func (m YoutubeMapper) Map(userID uuid.UUID, activityID string, metadata eyeofsauron.GetActivityActivityResponseDataActivityMetadata) (*models.Activity, error) {
+	meta, ok := metadata.(*eyeofsauron.GetActivityActivityResponseDataActivityMetadataYoutubeActivityMetadata)
+	if !ok {
+		return nil, unexpectedMetadata(m.Source(), metadata)
+	}
+
+	date, err := parseActivityDate(meta.Date)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse date: %w", err)
+	}
+
+	return &models.Activity{
+		UserID:             userID,
+		DataType:           m.DataType(),
+		ProviderActivityID: activityID,
+		Title:              meta.Title,
+		Date:               date,
+		ContentType:        string(meta.ContentType),
+		PercentageWatched:  meta.PercentageWatched,
+		Subject:            mapIdentifiers(meta.Subject),
+	}, nil
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -27,23 +30,84 @@ type User struct {
 type DataType string
 
 var (
-	DataTypeNetflix DataType = "netflix"
+	DataTypeNetflix     DataType = "netflix"
+	DataTypePlaystation DataType = "playstation"
+	DataTypeYoutube     DataType = "youtube"
+	DataTypeAmazon      DataType = "amazon"
+	DataTypeUber        DataType = "uber"
+	DataTypeInstacart   DataType = "instacart"
+)
+
+// DataTypes lists every source a user can link.
+var DataTypes = []DataType{
+	DataTypeNetflix,
+	DataTypePlaystation,
+	DataTypeYoutube,
+	DataTypeAmazon,
+	DataTypeUber,
+	DataTypeInstacart,
+}
+
+var ErrUnsupportedDataType = errors.New("unsupported source")
+
+// ParseDataType validates a source name coming from a request.
+func ParseDataType(value string) (DataType, error) {
+	for _, dataType := range DataTypes {
+		if string(dataType) == strings.ToLower(value) {
+			return dataType, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnsupportedDataType, value)
+}
+
+const TripStatusCompleted = "COMPLETED"
+
+const (
+	OrderItemStatusFound    = "FOUND"
+	OrderItemStatusReplaced = "REPLACED"
+	OrderItemStatusToRefund = "TOREFUND"
 )
 
 type DataKey struct {
 	Base
-	UserID   uuid.UUID `gorm:"type:UUID"`
-	DataType DataType
+	UserID   uuid.UUID `gorm:"type:UUID;uniqueIndex:idx_data_key_user_source"`
+	DataType DataType  `gorm:"uniqueIndex:idx_data_key_user_source"`
 	Key      string
+
+	// sync state, LastSyncedAt stays nil until the first full import completes. LastActivityID is the
+	// activity gandalf listed first on the last sync.
+	LastPage       int64
+	LastActivityID string
+	LastSyncedAt   *time.Time
+	Total          int64
+
+	// InactiveAt is set once gandalf rejects the key, it is not synced again until the user reconnects
+	InactiveAt     *time.Time
+	InactiveReason string
+}
+
+func (d DataKey) Active() bool {
+	return d.InactiveAt == nil
 }
 
 type Activity struct {
 	Base
 	UserID             uuid.UUID    `gorm:"type:UUID" json:"user_id"`
+	DataType           DataType     `gorm:"index;default:netflix" json:"data_type"`
 	ProviderActivityID string       `json:"provider_activity_id" gorm:"uniqueIndex"`
 	Subject            []Identifier `json:"subject"`
 	Title              string       `json:"title"`
 	Date               time.Time    `json:"date,omitempty"`
+	ContentType        string       `gorm:"default:''" json:"content_type,omitempty"`
+	PercentageWatched  int          `json:"percentage_watched,omitempty"`
+	Quantity           int          `json:"quantity,omitempty"`
+	Amount             int64        `json:"amount,omitempty"`
+	Currency           string       `json:"currency,omitempty"`
+	City               string       `json:"city,omitempty"`
+	Distance           float64      `json:"distance,omitempty"`
+	Status             string       `json:"status,omitempty"`
+	EndedAt            *time.Time   `json:"ended_at,omitempty"`
+	Items              []OrderItem  `json:"items,omitempty"`
 	Processed          bool         `gorm:"default:false" json:"processed"`
 }
 
@@ -56,25 +120,269 @@ type ActivityDataSet struct {
 
 type Identifier struct {
 	Base
-	ActivityID     uuid.UUID `gorm:"type:UUID" json:"activity_id"`
-	Value          string    `json:"value"`
-	IdentifierType string    `json:"identifier_type"`
+	ActivityID     uuid.UUID `gorm:"type:UUID;uniqueIndex:idx_identifier" json:"activity_id"`
+	Value          string    `gorm:"uniqueIndex:idx_identifier" json:"value"`
+	IdentifierType string    `gorm:"uniqueIndex:idx_identifier" json:"identifier_type"`
+}
+
+type OrderItem struct {
+	Base
+	ActivityID  uuid.UUID `gorm:"type:UUID;uniqueIndex:idx_order_item" json:"activity_id"`
+	ItemID      string    `gorm:"uniqueIndex:idx_order_item" json:"item_id"`
+	ProductName string    `json:"product_name"`
+	UnitPrice   int64     `json:"unit_price"`
+	Currency    string    `json:"currency"`
+	Status      string    `json:"status"`
+	Quantity    int64     `json:"quantity"`
+}
+
+type Trait struct {
+	Base
+	UserID          uuid.UUID `gorm:"type:UUID;uniqueIndex:idx_trait_history" json:"-"`
+	DataType        DataType  `gorm:"uniqueIndex:idx_trait_history" json:"source"`
+	Label           string    `gorm:"uniqueIndex:idx_trait_history" json:"label"`
+	Timestamp       time.Time `gorm:"uniqueIndex:idx_trait_history" json:"timestamp"`
+	ProviderTraitID string    `json:"-"`
+	Value           string    `json:"value"`
+}
+
+// SourcePurge counts the rows removed when a user unlinks a source.
+type SourcePurge struct {
+	Source         DataType `json:"source"`
+	DataKeys       int64    `json:"data_keys"`
+	Activities     int64    `json:"activities"`
+	Identifiers    int64    `json:"identifiers"`
+	Episodes       int64    `json:"episodes"`
+	OrderItems     int64    `json:"order_items"`
+	ActivityStats  int64    `json:"activity_stats"`
+	Traits         int64    `json:"traits"`
+	SyncRuns       int64    `json:"sync_runs"`
+	ViewingStreaks int64    `json:"viewing_streaks"`
+	BingeSessions  int64    `json:"binge_sessions"`
+	CancelledTasks int      `json:"cancelled_tasks"`
+}
+
+// StatDiscrepancy is a month where the stored stat total differs from the processed activities it should count.
+type StatDiscrepancy struct {
+	UserID      uuid.UUID `json:"user_id"`
+	DataType    DataType  `json:"source"`
+	ContentType string    `json:"content_type"`
+	Year        int       `json:"year"`
+	Month       int       `json:"month"`
+	Activities  int       `json:"activities"`
+	StatTotal   int       `json:"stat_total"`
+}
+
+// StuckActivities counts a user's activities left unprocessed for longer than the stats task should take.
+type StuckActivities struct {
+	UserID      uuid.UUID `json:"user_id"`
+	Count       int       `json:"count"`
+	OldestSince time.Time `json:"oldest_since"`
+}
+
+type StatsConsistencyReport struct {
+	CheckedAt     time.Time         `json:"checked_at"`
+	Discrepancies []StatDiscrepancy `json:"discrepancies"`
+	Stuck         []StuckActivities `json:"stuck_unprocessed"`
+	// Repaired lists the users whose stats were rebuilt, it is only filled in when fixing
+	Repaired []uuid.UUID `json:"repaired"`
+	// Failed maps users whose rebuild failed to the error
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+type SyncRunKind string
+
+const (
+	SyncRunKindImport SyncRunKind = "import"
+	SyncRunKindStats  SyncRunKind = "stats"
+)
+
+type SyncStatus string
+
+const (
+	SyncStatusRunning   SyncStatus = "running"
+	SyncStatusSucceeded SyncStatus = "succeeded"
+	SyncStatusFailed    SyncStatus = "failed"
+)
+
+type SyncRun struct {
+	Base
+	UserID              uuid.UUID   `gorm:"type:UUID;index:idx_sync_run_lookup" json:"-"`
+	DataType            DataType    `gorm:"index:idx_sync_run_lookup" json:"source"`
+	Kind                SyncRunKind `json:"kind"`
+	Status              SyncStatus  `json:"status"`
+	PagesFetched        int         `json:"pages_fetched"`
+	ActivitiesInserted  int         `json:"activities_inserted"`
+	DuplicatesSkipped   int         `json:"duplicates_skipped"`
+	ParseFailures       int         `json:"parse_failures"`
+	ActivitiesProcessed int         `json:"activities_processed"`
+	LastError           string      `json:"last_error,omitempty"`
+	StartedAt           time.Time   `json:"started_at"`
+	FinishedAt          *time.Time  `json:"finished_at,omitempty"`
 }
 
 type ActivityStat struct {
 	Base
-	UserID uuid.UUID `gorm:"type:uuid;primary_key;"`
-	Year   int       `gorm:"primary_key;"`
-	Month  int       `gorm:"primary_key;"`
-	Total  int
+	UserID      uuid.UUID `gorm:"type:uuid;primary_key;"`
+	DataType    DataType  `gorm:"primary_key;default:netflix"`
+	ContentType string    `gorm:"primary_key;default:''"`
+	// Currency keeps spend in different currencies apart, it is empty for activities without an amount
+	Currency string `gorm:"primary_key;default:''"`
+	Year     int    `gorm:"primary_key;"`
+	Month    int    `gorm:"primary_key;"`
+	Total    int
+	// Spend is in minor units of Currency
+	Spend int64
+}
+
+// Series is a netflix show, shared by every user who watched it.
+type Series struct {
+	Base
+	Title string `gorm:"uniqueIndex" json:"title"`
+}
+
+// Episode is the parsed title of one netflix viewing. Movies and titles the parser could not place are kept
+// too, with their raw title as Name and no series.
+type Episode struct {
+	Base
+	UserID       uuid.UUID  `gorm:"type:UUID;index" json:"-"`
+	ActivityID   uuid.UUID  `gorm:"type:UUID;uniqueIndex" json:"activity_id"`
+	SeriesID     *uuid.UUID `gorm:"type:UUID;index" json:"series_id,omitempty"`
+	Kind         string     `gorm:"index" json:"kind"`
+	Season       string     `json:"season,omitempty"`
+	SeasonNumber int        `json:"season_number,omitempty"`
+	Name         string     `json:"name"`
+	RawTitle     string     `json:"raw_title"`
+	WatchedAt    time.Time  `json:"watched_at"`
+}
+
+type SeriesStat struct {
+	Series      string    `json:"series"`
+	Episodes    int       `json:"episodes"`
+	Seasons     int       `json:"seasons"`
+	LastWatched time.Time `json:"last_watched"`
+}
+
+// CompletedSeason is a season the user watched through. Gandalf has no episode counts, so KnownEpisodes is the
+// most episodes of the season any user watched, and a season counts as completed once the user watched that many
+// and moved on to a later season. The last known season of a series has nothing to move on to, it counts once
+// another user stopped at the same episode count too, so a season nobody else watched is never listed.
+type CompletedSeason struct {
+	Series        string    `json:"series"`
+	Season        string    `json:"season"`
+	SeasonNumber  int       `json:"season_number"`
+	Episodes      int       `json:"episodes"`
+	KnownEpisodes int       `json:"known_episodes"`
+	FinishedAt    time.Time `json:"finished_at"`
+}
+
+type TitleSplit struct {
+	Movies   int `json:"movies"`
+	Episodes int `json:"episodes"`
+	Series   int `json:"series"`
+	Unparsed int `json:"unparsed"`
+}
+
+type DayStat struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+type WeekdayStat struct {
+	// Weekday counts from Sunday as 0, like time.Weekday
+	Weekday int    `json:"weekday"`
+	Day     string `json:"day"`
+	Count   int    `json:"count"`
+}
+
+// ActivityHeatmap holds one entry per calendar day between From and To, including days without activity.
+type ActivityHeatmap struct {
+	From     string        `json:"from"`
+	To       string        `json:"to"`
+	Max      int           `json:"max"`
+	Days     []DayStat     `json:"days"`
+	Weekdays []WeekdayStat `json:"weekdays"`
+}
+
+type TripCityStat struct {
+	City     string  `json:"city"`
+	Currency string  `json:"currency"`
+	Trips    int     `json:"trips"`
+	Distance float64 `json:"distance"`
+	Spend    float64 `json:"spend"`
+}
+
+type TripMonthStat struct {
+	Year     int     `json:"year"`
+	Month    int     `json:"month"`
+	Currency string  `json:"currency"`
+	Trips    int     `json:"trips"`
+	Distance float64 `json:"distance"`
+	Spend    float64 `json:"spend"`
+}
+
+type TripStats struct {
+	Cities        []TripCityStat  `json:"cities"`
+	Months        []TripMonthStat `json:"months"`
+	TotalTrips    int             `json:"total_trips"`
+	TotalDistance float64         `json:"total_distance"`
+}
+
+type ProductStat struct {
+	ProductName string  `json:"product_name"`
+	Currency    string  `json:"currency"`
+	Quantity    int64   `json:"quantity"`
+	Orders      int     `json:"orders"`
+	Spend       float64 `json:"spend"`
+}
+
+type OrderItemRates struct {
+	Total        int     `json:"total"`
+	Found        int     `json:"found"`
+	Replaced     int     `json:"replaced"`
+	Refunded     int     `json:"refunded"`
+	ReplacedRate float64 `json:"replaced_rate" gorm:"-"`
+	RefundRate   float64 `json:"refund_rate" gorm:"-"`
+}
+
+type OrderStats struct {
+	TopProducts []ProductStat  `json:"top_products"`
+	ItemRates   OrderItemRates `json:"item_rates"`
 }
 
 type YearData struct {
 	Labels []int
+	// Spend holds monthly spend per currency, amounts in different currencies are never summed
+	Spend  map[string][]float64
 	Months []string
 }
 
 type YearDataStat struct {
 	YearData    map[int]YearData `json:"year_data"`
 	CurrentYear string           `json:"current_year"`
+	Streak      *ViewingStreak   `json:"streak,omitempty"`
+	Binges      []BingeSession   `json:"binges"`
+}
+
+// ViewingStreak holds a user's runs of consecutive UTC days with viewing activity. The current streak
+// still counts when the last viewing day was yesterday.
+type ViewingStreak struct {
+	Base
+	UserID        uuid.UUID  `gorm:"type:UUID;uniqueIndex" json:"-"`
+	Longest       int        `json:"longest"`
+	LongestStart  *time.Time `json:"longest_start,omitempty"`
+	LongestEnd    *time.Time `json:"longest_end,omitempty"`
+	Current       int        `json:"current"`
+	CurrentStart  *time.Time `json:"current_start,omitempty"`
+	LastViewingOn *time.Time `json:"last_viewing_on,omitempty"`
+}
+
+// BingeSession is a day on which a user watched several episodes of the same series.
+type BingeSession struct {
+	Base
+	UserID   uuid.UUID `gorm:"type:UUID;index" json:"-"`
+	Date     time.Time `json:"date"`
+	Series   string    `json:"series"`
+	Episodes int       `json:"episodes"`
+	Titles   []string  `gorm:"serializer:json" json:"titles"`
 }
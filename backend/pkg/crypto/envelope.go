@@ -0,0 +1,200 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// sealedPrefix marks values produced by Envelope.Seal, anything else is treated as legacy plaintext.
+const sealedPrefix = "enc:v1:"
+
+// Envelope encrypts values with a fresh data encryption key each, and stores that key wrapped by a
+// master key. Rotating the master key only rewraps the data keys, the values are never decrypted.
+type Envelope struct {
+	currentID string
+	masters   map[string]cipher.AEAD
+}
+
+// NewEnvelope takes base64 encoded 32 byte master keys. Values are sealed with current, previous keys
+// are only used to open and rotate values sealed before a rotation.
+func NewEnvelope(current string, previous ...string) (*Envelope, error) {
+	envelope := &Envelope{
+		masters: make(map[string]cipher.AEAD),
+	}
+
+	for i, encoded := range append([]string{current}, previous...) {
+		encoded = strings.TrimSpace(encoded)
+		if encoded == "" {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode master key: %v", err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+
+		id := keyID(key)
+		envelope.masters[id] = aead
+		if i == 0 {
+			envelope.currentID = id
+		}
+	}
+
+	if envelope.currentID == "" {
+		return nil, fmt.Errorf("a master key is required")
+	}
+	return envelope, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// keyID fingerprints a master key so sealed values record which key wrapped them without revealing it.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed value is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// Seal encrypts plaintext into "enc:v1:<master key id>:<wrapped data key>:<ciphertext>".
+func (e *Envelope) Seal(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(dataAEAD, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return e.wrap(e.currentID, dataKey, ciphertext)
+}
+
+func (e *Envelope) wrap(masterID string, dataKey, ciphertext []byte) (string, error) {
+	wrapped, err := seal(e.masters[masterID], dataKey, []byte(masterID))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedPrefix + strings.Join([]string{
+		masterID,
+		base64.RawStdEncoding.EncodeToString(wrapped),
+		base64.RawStdEncoding.EncodeToString(ciphertext),
+	}, ":"), nil
+}
+
+// unwrap returns the data key and ciphertext of a sealed value.
+func (e *Envelope) unwrap(sealed string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, fmt.Errorf("malformed sealed value")
+	}
+
+	masterID := parts[0]
+	master, ok := e.masters[masterID]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("unknown master key %s", masterID)
+	}
+
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("malformed wrapped key: %v", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("malformed ciphertext: %v", err)
+	}
+
+	dataKey, err := open(master, wrapped, []byte(masterID))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("unable to unwrap data key: %v", err)
+	}
+	return masterID, dataKey, ciphertext, nil
+}
+
+func (e *Envelope) Open(sealed string) (string, error) {
+	if !IsSealed(sealed) {
+		return "", fmt.Errorf("value is not sealed")
+	}
+
+	_, dataKey, ciphertext, err := e.unwrap(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(dataAEAD, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt value: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether value is plaintext or sealed under a master key other than the current one.
+func (e *Envelope) NeedsRotation(value string) bool {
+	if !IsSealed(value) {
+		return true
+	}
+	return !strings.HasPrefix(value, sealedPrefix+e.currentID+":")
+}
+
+// Rotate rewraps a sealed value's data key with the current master key, plaintext values are sealed.
+func (e *Envelope) Rotate(value string) (string, error) {
+	if !IsSealed(value) {
+		return e.Seal(value)
+	}
+
+	_, dataKey, ciphertext, err := e.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	return e.wrap(e.currentID, dataKey, ciphertext)
+}
@@ -0,0 +1,143 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func masterKey(fill byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, 32))
+}
+
+func newTestEnvelope(t *testing.T, current string, previous ...string) *Envelope {
+	t.Helper()
+
+	envelope, err := NewEnvelope(current, previous...)
+	if err != nil {
+		t.Fatalf("NewEnvelope returned error: %v", err)
+	}
+	return envelope
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	envelope := newTestEnvelope(t, masterKey(1))
+
+	sealed, err := envelope.Seal("data-key")
+	if err != nil {
+		t.Fatalf("Seal returned error: %v", err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "data-key") {
+		t.Fatalf("Seal returned %q, want an opaque sealed value", sealed)
+	}
+
+	opened, err := envelope.Open(sealed)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if opened != "data-key" {
+		t.Errorf("Open = %q, want %q", opened, "data-key")
+	}
+
+	again, err := envelope.Seal("data-key")
+	if err != nil {
+		t.Fatalf("Seal returned error: %v", err)
+	}
+	if again == sealed {
+		t.Error("sealing the same value twice gave the same result")
+	}
+}
+
+func TestOpenRejectsTamperedValues(t *testing.T) {
+	envelope := newTestEnvelope(t, masterKey(1))
+	sealed, err := envelope.Seal("data-key")
+	if err != nil {
+		t.Fatalf("Seal returned error: %v", err)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	tamper := func(part int) string {
+		tampered := append([]string(nil), parts...)
+		raw, err := base64.RawStdEncoding.DecodeString(tampered[part])
+		if err != nil {
+			t.Fatalf("unable to decode part %d: %v", part, err)
+		}
+		raw[len(raw)-1] ^= 1
+		tampered[part] = base64.RawStdEncoding.EncodeToString(raw)
+		return sealedPrefix + strings.Join(tampered, ":")
+	}
+
+	tests := map[string]string{
+		"wrapped data key": tamper(1),
+		"ciphertext":       tamper(2),
+		"master key id":    sealedPrefix + "00000000:" + parts[1] + ":" + parts[2],
+		"missing part":     sealedPrefix + parts[0] + ":" + parts[1],
+		"plaintext":        "data-key",
+	}
+	for name, value := range tests {
+		if opened, err := envelope.Open(value); err == nil {
+			t.Errorf("Open of a value with a tampered %s returned %q, want an error", name, opened)
+		}
+	}
+}
+
+func TestRotateToNewMasterKey(t *testing.T) {
+	before := newTestEnvelope(t, masterKey(1))
+	sealed, err := before.Seal("data-key")
+	if err != nil {
+		t.Fatalf("Seal returned error: %v", err)
+	}
+
+	rotating := newTestEnvelope(t, masterKey(2), masterKey(1))
+	if !rotating.NeedsRotation(sealed) {
+		t.Fatal("NeedsRotation of a value sealed under the previous key = false, want true")
+	}
+	if opened, err := rotating.Open(sealed); err != nil || opened != "data-key" {
+		t.Fatalf("Open with the previous key listed = %q, %v, want the value", opened, err)
+	}
+
+	rotated, err := rotating.Rotate(sealed)
+	if err != nil {
+		t.Fatalf("Rotate returned error: %v", err)
+	}
+	if rotating.NeedsRotation(rotated) {
+		t.Error("NeedsRotation of a rotated value = true, want false")
+	}
+
+	after := newTestEnvelope(t, masterKey(2))
+	opened, err := after.Open(rotated)
+	if err != nil {
+		t.Fatalf("Open with only the new key returned error: %v", err)
+	}
+	if opened != "data-key" {
+		t.Errorf("Open = %q, want %q", opened, "data-key")
+	}
+	if _, err := after.Open(sealed); err == nil {
+		t.Error("Open of a value sealed under a dropped key returned no error")
+	}
+}
+
+func TestNeedsRotation(t *testing.T) {
+	envelope := newTestEnvelope(t, masterKey(1))
+
+	if !envelope.NeedsRotation("data-key") {
+		t.Error("NeedsRotation of plaintext = false, want true")
+	}
+
+	sealed, err := envelope.Seal("data-key")
+	if err != nil {
+		t.Fatalf("Seal returned error: %v", err)
+	}
+	if envelope.NeedsRotation(sealed) {
+		t.Error("NeedsRotation of a value sealed with the current key = true, want false")
+	}
+
+	rotated, err := envelope.Rotate("data-key")
+	if err != nil {
+		t.Fatalf("Rotate of plaintext returned error: %v", err)
+	}
+	if opened, err := envelope.Open(rotated); err != nil || opened != "data-key" {
+		t.Errorf("Open of rotated plaintext = %q, %v, want the value", opened, err)
+	}
+}
@@ -0,0 +1,136 @@
+package money
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Amount is a monetary value held in the minor units of its currency, cents for USD and yen for JPY, to avoid
+// float drift.
+type Amount struct {
+	Value    int64
+	Currency string
+}
+
+var currencySymbols = map[string]string{
+	"$":   "USD",
+	"US$": "USD",
+	"£":   "GBP",
+	"€":   "EUR",
+	"₹":   "INR",
+	"¥":   "JPY",
+	"C$":  "CAD",
+	"A$":  "AUD",
+}
+
+// zeroDecimalCurrencies have no minor unit, their amounts are stored as whole units.
+var zeroDecimalCurrencies = map[string]bool{
+	"JPY": true,
+	"KRW": true,
+	"VND": true,
+	"CLP": true,
+	"ISK": true,
+}
+
+// DefaultCurrency is assumed when gandalf returns a bare number.
+const DefaultCurrency = "USD"
+
+// Decimals returns how many digits of the currency's minor unit an Amount holds.
+func Decimals(currency string) int {
+	if zeroDecimalCurrencies[currency] {
+		return 0
+	}
+	return 2
+}
+
+// Parse converts gandalf cost strings such as "$12.99", "-$5.00", "12,99 €" or "GBP 1,024.50" into an Amount.
+func Parse(raw string) (Amount, error) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return Amount{}, fmt.Errorf("empty amount")
+	}
+
+	first := strings.IndexFunc(value, unicode.IsDigit)
+	last := strings.LastIndexFunc(value, unicode.IsDigit)
+	if first < 0 {
+		return Amount{}, fmt.Errorf("invalid amount %q: no digits", raw)
+	}
+	prefix, number, suffix := value[:first], value[first:last+1], value[last+1:]
+
+	// refunds come as "-$5.00" or "$-5.00", the sign can sit on either side of the symbol
+	negative := false
+	if strings.ContainsAny(prefix, "-−") {
+		negative = true
+		prefix = strings.NewReplacer("-", "", "−", "").Replace(prefix)
+	}
+
+	currency := ""
+	for _, marker := range []string{strings.TrimSpace(prefix), strings.TrimSpace(suffix)} {
+		if marker == "" {
+			continue
+		}
+		if code, ok := currencySymbols[marker]; ok {
+			currency = code
+			break
+		}
+		if len(marker) == 3 && strings.ToUpper(marker) == marker {
+			currency = marker
+			break
+		}
+	}
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	decimals := Decimals(currency)
+	parsed, err := strconv.ParseFloat(normalizeNumber(number), 64)
+	if err != nil {
+		return Amount{}, fmt.Errorf("invalid amount %q: %w", raw, err)
+	}
+	if negative {
+		parsed = -parsed
+	}
+
+	return Amount{
+		Value:    int64(math.Round(parsed * math.Pow10(decimals))),
+		Currency: currency,
+	}, nil
+}
+
+// normalizeNumber rewrites a number using either "." or "," as its decimal separator, such as "1,024.50" or
+// "1.024,50", into "1024.50". A lone separator followed by exactly three digits is read as thousands grouping.
+func normalizeNumber(number string) string {
+	number = strings.NewReplacer(" ", "", "\u00a0", "", "'", "").Replace(number)
+
+	decimal := -1
+	lastDot, lastComma := strings.LastIndex(number, "."), strings.LastIndex(number, ",")
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		decimal = max(lastDot, lastComma)
+	case lastDot >= 0 || lastComma >= 0:
+		separator := max(lastDot, lastComma)
+		repeated := strings.Count(number, number[separator:separator+1]) > 1
+		grouping := len(number)-separator-1 == 3
+		if !repeated && !grouping {
+			decimal = separator
+		}
+	}
+
+	whole, fraction := number, ""
+	if decimal >= 0 {
+		whole, fraction = number[:decimal], number[decimal+1:]
+	}
+	whole = strings.NewReplacer(".", "", ",", "").Replace(whole)
+	if fraction == "" {
+		return whole
+	}
+	return whole + "." + fraction
+}
+
+// Float returns the amount in whole units of its currency.
+func (a Amount) Float() float64 {
+	return float64(a.Value) / math.Pow10(Decimals(a.Currency))
+}
@@ -0,0 +1,66 @@
+package money
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Amount
+	}{
+		{"$12.99", Amount{Value: 1299, Currency: "USD"}},
+		{"12.99", Amount{Value: 1299, Currency: "USD"}},
+		{"12.99 EUR", Amount{Value: 1299, Currency: "EUR"}},
+		{"GBP 1,024.50", Amount{Value: 102450, Currency: "GBP"}},
+		{"US$3", Amount{Value: 300, Currency: "USD"}},
+		{"-$5.00", Amount{Value: -500, Currency: "USD"}},
+		{"$-5.00", Amount{Value: -500, Currency: "USD"}},
+		{"- £7.25", Amount{Value: -725, Currency: "GBP"}},
+		{"€12,99", Amount{Value: 1299, Currency: "EUR"}},
+		{"12,99 €", Amount{Value: 1299, Currency: "EUR"}},
+		{"1.024,50 €", Amount{Value: 102450, Currency: "EUR"}},
+		{"€1.024", Amount{Value: 102400, Currency: "EUR"}},
+		{"$1,024", Amount{Value: 102400, Currency: "USD"}},
+		{"$1,234,567.89", Amount{Value: 123456789, Currency: "USD"}},
+		{"1 234,50 EUR", Amount{Value: 123450, Currency: "EUR"}},
+		{"¥1200", Amount{Value: 1200, Currency: "JPY"}},
+		{"¥1,200", Amount{Value: 1200, Currency: "JPY"}},
+		{"JPY 980", Amount{Value: 980, Currency: "JPY"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := Parse(tt.raw)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.raw, err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, raw := range []string{"", "   ", "$", "free"} {
+		if got, err := Parse(raw); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", raw, got)
+		}
+	}
+}
+
+func TestAmountFloat(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		want   float64
+	}{
+		{Amount{Value: 1299, Currency: "USD"}, 12.99},
+		{Amount{Value: -500, Currency: "EUR"}, -5},
+		{Amount{Value: 1200, Currency: "JPY"}, 1200},
+	}
+
+	for _, tt := range tests {
+		if got := tt.amount.Float(); got != tt.want {
+			t.Errorf("%+v.Float() = %v, want %v", tt.amount, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package postgres
+
+import (
+	"fmt"
+	"gandalf-data-aggregator/models"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Migrate auto migrates every model, then applies the schema changes AutoMigrate leaves out on existing tables.
+func Migrate(db *gorm.DB) error {
+	// order items used to be inserted again on every replayed page, and those of skipped duplicates without
+	// an activity, they have to go before their unique index can be created
+	if !db.Migrator().HasIndex(&models.OrderItem{}, "idx_order_item") {
+		if err := deleteOrphanedRows(db, &models.OrderItem{}); err != nil {
+			return err
+		}
+	}
+	err := prepareUniqueIndex(db, &models.OrderItem{}, "idx_order_item", "created_at", "activity_id", "item_id")
+	if err != nil {
+		return err
+	}
+
+	// subjects had the same problem, pages replayed by a resumed import stored them again
+	if !db.Migrator().HasIndex(&models.Identifier{}, "idx_identifier") {
+		if err := deleteOrphanedRows(db, &models.Identifier{}); err != nil {
+			return err
+		}
+	}
+	err = prepareUniqueIndex(db, &models.Identifier{}, "idx_identifier", "created_at", "activity_id", "value", "identifier_type")
+	if err != nil {
+		return err
+	}
+
+	// concurrent callbacks could link the same source twice, only the most recently updated key is kept
+	err = prepareUniqueIndex(db, &models.DataKey{}, "idx_data_key_user_source", "updated_at", "user_id", "data_type")
+	if err != nil {
+		return err
+	}
+
+	err = db.AutoMigrate(&models.User{}, &models.Activity{}, &models.Identifier{}, &models.DataKey{}, &models.ActivityStat{}, &models.OrderItem{}, &models.Trait{}, &models.SyncRun{}, &models.ViewingStreak{}, &models.BingeSession{}, &models.Series{}, &models.Episode{})
+	if err != nil {
+		return err
+	}
+
+	// activities stored before content types were tracked hold nulls, stats group them under ''
+	err = db.Unscoped().Model(&models.Activity{}).Where("content_type IS NULL").UpdateColumn("content_type", "").Error
+	if err != nil {
+		return err
+	}
+
+	// activity stats were first keyed by (user_id, year, month) only, stats of a second source or content type
+	// in the same month would collide with it and the upsert has no constraint matching its conflict target
+	return syncPrimaryKey(db, &models.ActivityStat{})
+}
+
+// syncPrimaryKey recreates a table's primary key when it differs from the one declared on model,
+// AutoMigrate only sets primary keys when it creates a table.
+func syncPrimaryKey(db *gorm.DB, model interface{}) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	table, want := stmt.Schema.Table, stmt.Schema.PrimaryFieldDBNames
+
+	var current []string
+	tx := db.Raw(`
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = ?::regclass AND i.indisprimary`, table).
+		Scan(&current)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if sameColumns(current, want) {
+		return nil
+	}
+
+	log.Info().Msgf("Migrating primary key of %s from (%s) to (%s)", table, strings.Join(current, ", "), strings.Join(want, ", "))
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var constraint string
+		err := tx.Raw("SELECT conname FROM pg_constraint WHERE conrelid = ?::regclass AND contype = 'p'", table).
+			Scan(&constraint).Error
+		if err != nil {
+			return err
+		}
+
+		if constraint != "" {
+			err = tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", quote(table), quote(constraint))).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// key columns added to a populated table may hold nulls, which a primary key rejects
+		for _, field := range stmt.Schema.PrimaryFields {
+			if !field.HasDefaultValue {
+				continue
+			}
+			err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s = DEFAULT WHERE %s IS NULL", quote(table), quote(field.DBName), quote(field.DBName))).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		columns := make([]string, len(want))
+		for i, column := range want {
+			columns[i] = quote(column)
+		}
+		return tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s)", quote(table), strings.Join(columns, ", "))).Error
+	})
+}
+
+func sameColumns(current, want []string) bool {
+	if len(current) != len(want) {
+		return false
+	}
+
+	columns := make(map[string]bool, len(current))
+	for _, column := range current {
+		columns[column] = true
+	}
+	for _, column := range want {
+		if !columns[column] {
+			return false
+		}
+	}
+	return true
+}
+
+func quote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+// prepareUniqueIndex removes the rows that would stop a new unique index on columns from being created, keeping
+// the row of each duplicate group with the latest keepLatest column. It does nothing once the index exists.
+func prepareUniqueIndex(db *gorm.DB, model interface{}, index string, keepLatest string, columns ...string) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(model) || migrator.HasIndex(model, index) {
+		return nil
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	table := quote(stmt.Schema.Table)
+
+	matches := make([]string, len(columns))
+	for i, column := range columns {
+		matches[i] = fmt.Sprintf("a.%s = b.%s", quote(column), quote(column))
+	}
+	result := db.Exec(fmt.Sprintf(
+		"DELETE FROM %s a USING %s b WHERE %s AND (a.%s, a.ctid) < (b.%s, b.ctid)",
+		table, table, strings.Join(matches, " AND "), quote(keepLatest), quote(keepLatest),
+	))
+	if result.Error != nil {
+		return result.Error
+	}
+
+	log.Info().Msgf("Removed %d duplicate rows from %s before creating %s", result.RowsAffected, stmt.Schema.Table, index)
+	return nil
+}
+
+// deleteOrphanedRows removes rows of an activity child table whose activity_id matches no activity.
+func deleteOrphanedRows(db *gorm.DB, model interface{}) error {
+	if !db.Migrator().HasTable(model) {
+		return nil
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+
+	result := db.Exec(fmt.Sprintf("DELETE FROM %s t WHERE NOT EXISTS (SELECT 1 FROM activities a WHERE a.id = t.activity_id)", quote(stmt.Schema.Table)))
+	if result.Error != nil {
+		return result.Error
+	}
+
+	log.Info().Msgf("Removed %d orphaned rows from %s", result.RowsAffected, stmt.Schema.Table)
+	return nil
+}
@@ -0,0 +1,39 @@
+// Package progress carries live import progress from the worker to the API process.
+package progress
+
+import (
+	"context"
+	"gandalf-data-aggregator/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Phase string
+
+const (
+	PhaseFetching    Phase = "fetching"
+	PhaseAggregating Phase = "aggregating"
+	PhaseDone        Phase = "done"
+	PhaseFailed      Phase = "failed"
+)
+
+// Event reports how far a user's sync for a single source has got.
+type Event struct {
+	UserID   uuid.UUID       `json:"-"`
+	DataType models.DataType `json:"source"`
+	Phase    Phase           `json:"phase"`
+	// Fetched is the number of activities read from gandalf so far, out of Total
+	Fetched int64     `json:"fetched"`
+	Total   int64     `json:"total"`
+	Page    int64     `json:"page,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+type Broker interface {
+	Publish(ctx context.Context, event Event) error
+
+	// Subscribe streams the events of a user until ctx is done
+	Subscribe(ctx context.Context, userID uuid.UUID) (<-chan Event, error)
+}
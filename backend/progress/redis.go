@@ -0,0 +1,81 @@
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// RedisBroker fans events out over redis pub/sub so the worker and API can run as separate processes.
+type RedisBroker struct {
+	client *redis.Client
+}
+
+func NewRedisBroker(redisURL string) (*RedisBroker, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse redis URL: %w", err)
+	}
+
+	return &RedisBroker{
+		client: redis.NewClient(opt),
+	}, nil
+}
+
+func channel(userID uuid.UUID) string {
+	return fmt.Sprintf("sync:progress:%s", userID)
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, channel(event.UserID), payload).Err()
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, userID uuid.UUID) (<-chan Event, error) {
+	pubsub := b.client.Subscribe(ctx, channel(userID))
+	// wait for the subscription to be confirmed so no event published after we return is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				var event Event
+				if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+					log.Error().Err(err).Msg("Unable to decode progress event")
+					continue
+				}
+				event.UserID = userID
+
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
@@ -2,8 +2,10 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"gandalf-data-aggregator/models"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -23,12 +25,13 @@ func NewPostgres(db *gorm.DB) *Postgres {
 func (pg *Postgres) Transaction(
 	ctx context.Context,
 	callback func(context.Context, *Postgres) error,
+	opts ...*sql.TxOptions,
 ) error {
 	session := pg.Db.Session(&gorm.Session{})
 	err := session.Transaction(func(tx *gorm.DB) error {
 		txpg := NewPostgres(tx)
 		return callback(ctx, txpg)
-	})
+	}, opts...)
 	if err != nil {
 		return fmt.Errorf("in transaction: %w", err)
 	}
@@ -36,19 +39,119 @@ func (pg *Postgres) Transaction(
 	return nil
 }
 
+// FindOrCreateDataKey stores the key of a user's source, replacing the key of a source linked before. It upserts
+// on idx_data_key_user_source so concurrent callbacks for the same source still leave a single key.
 func (s *Postgres) FindOrCreateDataKey(ctx context.Context, dataKey *models.DataKey) (*models.DataKey, error) {
-	tx := s.Db.Model(models.DataKey{}).
-		Where("user_id = ? AND data_type = ?", dataKey.UserID, dataKey.DataType).
-		Assign(&dataKey).
-		FirstOrCreate(&dataKey)
+	tx := s.Db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "data_type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"key", "updated_at"}),
+	}).
+		Model(models.DataKey{}).
+		Create(dataKey)
 
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
 
+	return s.GetDataKey(ctx, dataKey.UserID, dataKey.DataType)
+}
+
+func (s *Postgres) GetDataKey(ctx context.Context, userID uuid.UUID, dataType models.DataType) (*models.DataKey, error) {
+	var dataKey *models.DataKey
+	tx := s.Db.Model(models.DataKey{}).
+		Where("user_id = ? AND data_type = ?", userID, dataType).
+		First(&dataKey)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
 	return dataKey, nil
 }
 
+// LockDataKey share locks a user's data key until the transaction ends, so the source cannot be unlinked while
+// data is written for it. It returns gorm.ErrRecordNotFound once the source is unlinked.
+func (s *Postgres) LockDataKey(ctx context.Context, userID uuid.UUID, dataType models.DataType) error {
+	var dataKey models.DataKey
+	return s.Db.Clauses(clause.Locking{Strength: "SHARE"}).
+		Model(models.DataKey{}).
+		Select("id").
+		Where("user_id = ? AND data_type = ?", userID, dataType).
+		Take(&dataKey).Error
+}
+
+func (s *Postgres) UpdateDataKeySyncState(ctx context.Context, dataKey *models.DataKey) error {
+	return s.Db.Model(dataKey).
+		Select("last_page", "last_activity_id", "last_synced_at", "total").
+		Updates(dataKey).Error
+}
+
+// ListDataKeys pages through every data key in a stable order.
+func (s *Postgres) ListDataKeys(ctx context.Context, offset int, limit int) ([]models.DataKey, error) {
+	var dataKeys []models.DataKey
+	tx := s.Db.Model(models.DataKey{}).
+		Order("id").
+		Offset(offset).
+		Limit(limit).
+		Find(&dataKeys)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return dataKeys, nil
+}
+
+func (s *Postgres) UpdateDataKeyKey(ctx context.Context, dataKey *models.DataKey) error {
+	return s.Db.Model(dataKey).
+		Select("key").
+		Updates(dataKey).Error
+}
+
+func (s *Postgres) DeactivateDataKey(ctx context.Context, dataKey *models.DataKey, reason string) error {
+	inactiveAt := time.Now()
+	dataKey.InactiveAt = &inactiveAt
+	dataKey.InactiveReason = reason
+
+	return s.Db.Model(dataKey).
+		Select("inactive_at", "inactive_reason").
+		Updates(dataKey).Error
+}
+
+func (s *Postgres) ReactivateDataKey(ctx context.Context, dataKey *models.DataKey) error {
+	dataKey.InactiveAt = nil
+	dataKey.InactiveReason = ""
+
+	return s.Db.Model(dataKey).
+		Select("inactive_at", "inactive_reason").
+		Updates(dataKey).Error
+}
+
+// GetStaleDataKeys returns active data keys whose last completed sync, or unfinished first import, is older than staleBefore.
+func (s *Postgres) GetStaleDataKeys(ctx context.Context, staleBefore time.Time) ([]models.DataKey, error) {
+	var dataKeys []models.DataKey
+	tx := s.Db.Model(models.DataKey{}).
+		Where("inactive_at IS NULL").
+		Where("last_synced_at < ? OR (last_synced_at IS NULL AND updated_at < ?)", staleBefore, staleBefore).
+		Find(&dataKeys)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return dataKeys, nil
+}
+
+func (s *Postgres) GetDataKeysByUser(ctx context.Context, userID uuid.UUID, dataType models.DataType) ([]models.DataKey, error) {
+	var dataKeys []models.DataKey
+	tx := s.Db.Model(models.DataKey{}).
+		Where("user_id = ?", userID).
+		Scopes(byDataType(dataType)).
+		Find(&dataKeys)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return dataKeys, nil
+}
+
 func (s *Postgres) FindOrCreateUser(ctx context.Context, user *models.User) (*models.User, error) {
 	tx := s.Db.Model(models.User{}).Where("external_id = ? ", user.ExternalID).Assign(&user).FirstOrCreate(&user)
 
@@ -68,10 +171,24 @@ func (s *Postgres) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.U
 	return user, nil
 }
 
-func (s *Postgres) GetTotalActivitiesByUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+// GetUserIDsWithActivities returns every user that has imported at least one activity.
+func (s *Postgres) GetUserIDsWithActivities(ctx context.Context) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	tx := s.Db.Model(models.Activity{}).
+		Distinct("user_id").
+		Pluck("user_id", &userIDs)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return userIDs, nil
+}
+
+func (s *Postgres) GetTotalActivitiesByUser(ctx context.Context, userID uuid.UUID, dataType models.DataType) (int64, error) {
 	var count int64
 	tx := s.Db.Model(models.Activity{}).
 		Where("user_id = ?", userID).
+		Scopes(byDataType(dataType)).
 		Count(&count)
 
 	if tx.Error != nil {
@@ -88,15 +205,112 @@ func (s *Postgres) CreateActivity(ctx context.Context, userID uuid.UUID, activit
 	return activity, nil
 }
 
-func (s *Postgres) CreateActivities(ctx context.Context, activities []*models.Activity) ([]*models.Activity, error) {
-	tx := s.Db.Clauses(clause.OnConflict{DoNothing: true}).Model(models.Activity{}).Create(&activities)
-	if tx.Error != nil {
-		return nil, tx.Error
+// CreateActivities inserts activities skipping those already stored and returns how many were inserted.
+// Subjects and order items are only inserted for the activities that were new, so replaying a page neither
+// duplicates them nor ties them to the wrong activity.
+func (s *Postgres) CreateActivities(ctx context.Context, activities []*models.Activity) (int64, error) {
+	if len(activities) == 0 {
+		return 0, nil
+	}
+
+	// ids are generated here rather than by postgres so children can be matched to their activity without
+	// relying on the order of the rows returned for a batch that skipped duplicates
+	ids := make([]uuid.UUID, len(activities))
+	for i, activity := range activities {
+		if activity.ID == uuid.Nil {
+			activity.ID = uuid.New()
+		}
+		ids[i] = activity.ID
+	}
+
+	var inserted int64
+	err := s.Transaction(ctx, func(ctx context.Context, tx *Postgres) error {
+		result := tx.Db.Clauses(clause.OnConflict{DoNothing: true}).
+			Omit(clause.Associations).
+			Model(models.Activity{}).
+			Create(&activities)
+		if result.Error != nil {
+			return result.Error
+		}
+		inserted = result.RowsAffected
+		if inserted == 0 {
+			return nil
+		}
+
+		// the ids were generated for this call, so any of them stored now belongs to an activity it inserted
+		var storedIDs []uuid.UUID
+		result = tx.Db.Model(models.Activity{}).Where("id IN ?", ids).Pluck("id", &storedIDs)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		identifiers, items := activityChildren(activities, storedIDs)
+		if len(identifiers) > 0 {
+			result = tx.Db.Clauses(clause.OnConflict{DoNothing: true}).Create(&identifiers)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		if len(items) > 0 {
+			result = tx.Db.Clauses(clause.OnConflict{DoNothing: true}).Create(&items)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+// activityChildren returns the subjects and order items of the activities in insertedIDs, pointed at their activity.
+func activityChildren(activities []*models.Activity, insertedIDs []uuid.UUID) ([]*models.Identifier, []*models.OrderItem) {
+	inserted := make(map[uuid.UUID]bool, len(insertedIDs))
+	for _, id := range insertedIDs {
+		inserted[id] = true
+	}
+
+	var identifiers []*models.Identifier
+	var items []*models.OrderItem
+	for _, activity := range activities {
+		if !inserted[activity.ID] {
+			continue
+		}
+		for i := range activity.Subject {
+			activity.Subject[i].ActivityID = activity.ID
+			identifiers = append(identifiers, &activity.Subject[i])
+		}
+		for i := range activity.Items {
+			activity.Items[i].ActivityID = activity.ID
+			items = append(items, &activity.Items[i])
+		}
+	}
+	return identifiers, items
+}
+
+// byDataType narrows a query to a single source, an empty data type matches every source.
+func byDataType(dataType models.DataType) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if dataType == "" {
+			return db
+		}
+		return db.Where("data_type = ?", dataType)
 	}
-	return activities, nil
 }
 
-func (s *Postgres) GetActivitySetByUser(ctx context.Context, userID uuid.UUID, limit int, page int) (*models.ActivityDataSet, error) {
+// byContentType narrows a query to a single content type, an empty content type matches all of them.
+func byContentType(contentType string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if contentType == "" {
+			return db
+		}
+		return db.Where("content_type = ?", contentType)
+	}
+}
+
+func (s *Postgres) GetActivitySetByUser(ctx context.Context, userID uuid.UUID, dataType models.DataType, limit int, page int) (*models.ActivityDataSet, error) {
 	currentPage := page - 1
 	if currentPage < 0 {
 		currentPage = 0
@@ -106,7 +320,9 @@ func (s *Postgres) GetActivitySetByUser(ctx context.Context, userID uuid.UUID, l
 	tx := s.Db.Debug().Model(models.Activity{}).
 		Distinct().
 		Where("user_id = ?", userID).
+		Scopes(byDataType(dataType)).
 		Preload("Subject").
+		Preload("Items").
 		Order("date DESC").
 		Count(&activitySet.Total).
 		Limit(limit).
@@ -128,17 +344,76 @@ func (s *Postgres) BatchUpsertActivityStat(ctx context.Context, stats []*models.
 		Clauses(clause.OnConflict{
 			Columns: []clause.Column{
 				{Name: "user_id"},
+				{Name: "data_type"},
+				{Name: "content_type"},
+				{Name: "currency"},
 				{Name: "year"},
 				{Name: "month"},
 			},
 			DoUpdates: clause.Assignments(map[string]interface{}{
 				"total": gorm.Expr("EXCLUDED.total + activity_stats.total"),
+				"spend": gorm.Expr("EXCLUDED.spend + activity_stats.spend"),
 			}),
 		}).
 		Model(&models.ActivityStat{}).
 		Create(&stats).Error
 }
 
+// countedActivities matches the activities that count toward activity stats, trips only count once completed.
+// It mirrors countsTowardStats in the service.
+var countedActivities = fmt.Sprintf("NOT (data_type = '%s' AND status IS DISTINCT FROM '%s')", models.DataTypeUber, models.TripStatusCompleted)
+
+// RebuildActivityStatsByUser replaces a user's stats with a recount of their activities and marks every
+// activity processed. Months without activity are stored as zero, matching what GenerateActivityStats writes.
+// It must run inside a repeatable read Transaction so the recount and the processed flags see the same rows.
+func (s *Postgres) RebuildActivityStatsByUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	tx := s.Db.Unscoped().Where("user_id = ?", userID).Delete(&models.ActivityStat{})
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	tx = s.Db.Exec(`
+		WITH counts AS (
+			SELECT data_type, COALESCE(content_type, '') AS content_type, COALESCE(currency, '') AS currency,
+				EXTRACT(YEAR FROM date AT TIME ZONE 'UTC')::int AS year,
+				EXTRACT(MONTH FROM date AT TIME ZONE 'UTC')::int AS month,
+				COUNT(*) AS total,
+				COALESCE(SUM(amount), 0) AS spend
+			FROM activities
+			WHERE user_id = @user AND deleted_at IS NULL AND `+countedActivities+`
+			GROUP BY 1, 2, 3, 4, 5
+		), stat_groups AS (
+			SELECT data_type, content_type, currency, year, MAX(month) AS max_month
+			FROM counts
+			GROUP BY 1, 2, 3, 4
+		)
+		INSERT INTO activity_stats (user_id, data_type, content_type, currency, year, month, total, spend, created_at, updated_at)
+		SELECT @user, g.data_type, g.content_type, g.currency, g.year, m.month, COALESCE(c.total, 0), COALESCE(c.spend, 0), NOW(), NOW()
+		FROM stat_groups g
+		CROSS JOIN LATERAL generate_series(1, CASE
+			WHEN g.year = EXTRACT(YEAR FROM NOW() AT TIME ZONE 'UTC')::int
+				THEN GREATEST(EXTRACT(MONTH FROM NOW() AT TIME ZONE 'UTC')::int, g.max_month)
+			ELSE 12
+		END) AS m(month)
+		LEFT JOIN counts c
+			ON c.data_type = g.data_type AND c.content_type = g.content_type AND c.currency = g.currency
+			AND c.year = g.year AND c.month = m.month`,
+		sql.Named("user", userID),
+	)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	tx = s.Db.Model(&models.Activity{}).
+		Where("user_id = ?", userID).
+		Update("processed", true)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	return tx.RowsAffected, nil
+}
+
 func (s *Postgres) SetActivityStatsToProcessedByUser(ctx context.Context, activityIDs uuid.UUIDs) error {
 	tx := s.Db.Model(&models.Activity{}).
 		Where("id IN ?", activityIDs).
@@ -152,11 +427,12 @@ func (s *Postgres) SetActivityStatsToProcessedByUser(ctx context.Context, activi
 	return nil
 }
 
-func (s *Postgres) GetActivityStatsByUser(ctx context.Context, userID uuid.UUID) ([]models.ActivityStat, error) {
+func (s *Postgres) GetActivityStatsByUser(ctx context.Context, userID uuid.UUID, dataType models.DataType, contentType string) ([]models.ActivityStat, error) {
 	var stats []models.ActivityStat
 
 	tx := s.Db.Model(&models.ActivityStat{}).
 		Where("user_id = ?", userID).
+		Scopes(byDataType(dataType), byContentType(contentType)).
 		Find(&stats)
 
 	if tx.Error != nil {
@@ -166,7 +442,7 @@ func (s *Postgres) GetActivityStatsByUser(ctx context.Context, userID uuid.UUID)
 	return stats, nil
 }
 
-func (s *Postgres) FetchUnprocessedUserActivities(ctx context.Context, userID uuid.UUID, limit int, page int) (*models.ActivityDataSet, error) {
+func (s *Postgres) FetchUnprocessedUserActivities(ctx context.Context, userID uuid.UUID, dataType models.DataType, limit int, page int) (*models.ActivityDataSet, error) {
 	currentPage := page - 1
 	if currentPage < 0 {
 		currentPage = 0
@@ -175,7 +451,7 @@ func (s *Postgres) FetchUnprocessedUserActivities(ctx context.Context, userID uu
 	activitySet := &models.ActivityDataSet{}
 	tx := s.Db.Debug().Model(models.Activity{}).
 		Distinct().
-		Where("user_id = ? AND processed = ?", userID, false).
+		Where("user_id = ? AND data_type = ? AND processed = ?", userID, dataType, false).
 		Preload("Subject").
 		Order("date DESC").
 		Count(&activitySet.Total).
@@ -192,3 +468,500 @@ func (s *Postgres) FetchUnprocessedUserActivities(ctx context.Context, userID uu
 
 	return activitySet, nil
 }
+
+// GetTripCityStatsByUser aggregates completed trips per city and currency, spend is in minor units.
+func (s *Postgres) GetTripCityStatsByUser(ctx context.Context, userID uuid.UUID) ([]models.TripCityStat, error) {
+	var stats []models.TripCityStat
+
+	tx := s.Db.Model(&models.Activity{}).
+		Select("city, currency, COUNT(*) AS trips, COALESCE(SUM(distance), 0) AS distance, COALESCE(SUM(amount), 0) AS spend").
+		Where("user_id = ? AND data_type = ? AND status = ?", userID, models.DataTypeUber, models.TripStatusCompleted).
+		Group("city, currency").
+		Order("trips DESC").
+		Scan(&stats)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return stats, nil
+}
+
+// GetTripMonthStatsByUser aggregates completed trips per month and currency, spend is in minor units.
+func (s *Postgres) GetTripMonthStatsByUser(ctx context.Context, userID uuid.UUID) ([]models.TripMonthStat, error) {
+	var stats []models.TripMonthStat
+
+	tx := s.Db.Model(&models.Activity{}).
+		Select("EXTRACT(YEAR FROM date AT TIME ZONE 'UTC')::int AS year, EXTRACT(MONTH FROM date AT TIME ZONE 'UTC')::int AS month, currency, COUNT(*) AS trips, COALESCE(SUM(distance), 0) AS distance, COALESCE(SUM(amount), 0) AS spend").
+		Where("user_id = ? AND data_type = ? AND status = ?", userID, models.DataTypeUber, models.TripStatusCompleted).
+		Group("year, month, currency").
+		Order("year, month").
+		Scan(&stats)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return stats, nil
+}
+
+// GetDailyActivityCountsByUser counts activities per UTC day in [from, to), days without activity are omitted.
+func (s *Postgres) GetDailyActivityCountsByUser(ctx context.Context, userID uuid.UUID, dataType models.DataType, contentType string, from, to time.Time) ([]models.DayStat, error) {
+	var stats []models.DayStat
+
+	tx := s.Db.Model(&models.Activity{}).
+		Select("to_char(date AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS date, COUNT(*) AS count").
+		Where("user_id = ? AND date >= ? AND date < ?", userID, from, to).
+		Scopes(byDataType(dataType), byContentType(contentType)).
+		Group("1").
+		Order("1").
+		Scan(&stats)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return stats, nil
+}
+
+// GetTopOrderedProductsByUser ranks found items by quantity per product and currency, spend is in minor units.
+func (s *Postgres) GetTopOrderedProductsByUser(ctx context.Context, userID uuid.UUID, limit int) ([]models.ProductStat, error) {
+	var stats []models.ProductStat
+
+	tx := s.Db.Model(&models.OrderItem{}).
+		Select("order_items.product_name, order_items.currency, SUM(order_items.quantity) AS quantity, COUNT(DISTINCT order_items.activity_id) AS orders, SUM(order_items.unit_price * order_items.quantity) AS spend").
+		Joins("JOIN activities ON activities.id = order_items.activity_id").
+		Where("activities.user_id = ? AND order_items.status = ?", userID, models.OrderItemStatusFound).
+		Group("order_items.product_name, order_items.currency").
+		Order("quantity DESC").
+		Limit(limit).
+		Scan(&stats)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return stats, nil
+}
+
+func (s *Postgres) GetOrderItemRatesByUser(ctx context.Context, userID uuid.UUID) (*models.OrderItemRates, error) {
+	rates := &models.OrderItemRates{}
+
+	tx := s.Db.Model(&models.OrderItem{}).
+		Select(
+			"COUNT(*) AS total, "+
+				"COUNT(*) FILTER (WHERE order_items.status = ?) AS found, "+
+				"COUNT(*) FILTER (WHERE order_items.status = ?) AS replaced, "+
+				"COUNT(*) FILTER (WHERE order_items.status = ?) AS refunded",
+			models.OrderItemStatusFound, models.OrderItemStatusReplaced, models.OrderItemStatusToRefund,
+		).
+		Joins("JOIN activities ON activities.id = order_items.activity_id").
+		Where("activities.user_id = ?", userID).
+		Scan(rates)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return rates, nil
+}
+
+func (s *Postgres) CreateTraits(ctx context.Context, traits []*models.Trait) error {
+	return s.Db.Clauses(clause.OnConflict{DoNothing: true}).
+		Model(&models.Trait{}).
+		Create(&traits).Error
+}
+
+// GetLatestTraitsByUser returns the most recent value of every trait label per source.
+func (s *Postgres) GetLatestTraitsByUser(ctx context.Context, userID uuid.UUID) ([]models.Trait, error) {
+	var traits []models.Trait
+
+	tx := s.Db.Model(&models.Trait{}).
+		Select("DISTINCT ON (data_type, label) *").
+		Where("user_id = ?", userID).
+		Order("data_type, label, timestamp DESC").
+		Find(&traits)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return traits, nil
+}
+
+func (s *Postgres) GetTraitHistoryByUser(ctx context.Context, userID uuid.UUID, dataType models.DataType) ([]models.Trait, error) {
+	var traits []models.Trait
+
+	tx := s.Db.Model(&models.Trait{}).
+		Where("user_id = ?", userID).
+		Scopes(byDataType(dataType)).
+		Order("data_type, label, timestamp DESC").
+		Find(&traits)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return traits, nil
+}
+
+func (s *Postgres) CreateSyncRun(ctx context.Context, run *models.SyncRun) error {
+	return s.Db.Create(run).Error
+}
+
+// UpdateSyncRun persists a run's progress. It returns gorm.ErrRecordNotFound once the run was purged with its
+// source, unlike Save it never inserts the run again.
+func (s *Postgres) UpdateSyncRun(ctx context.Context, run *models.SyncRun) error {
+	tx := s.Db.Model(run).Select("*").Omit("created_at").Updates(run)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetLatestSyncRunsByUser returns the most recent run of each kind per source.
+func (s *Postgres) GetLatestSyncRunsByUser(ctx context.Context, userID uuid.UUID, dataType models.DataType) ([]models.SyncRun, error) {
+	var runs []models.SyncRun
+
+	tx := s.Db.Model(&models.SyncRun{}).
+		Select("DISTINCT ON (data_type, kind) *").
+		Where("user_id = ?", userID).
+		Scopes(byDataType(dataType)).
+		Order("data_type, kind, started_at DESC").
+		Find(&runs)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return runs, nil
+}
+
+// sourceActivityIDs selects the ids of a user's activities from one source, for deleting their children.
+func (s *Postgres) sourceActivityIDs(userID uuid.UUID, dataType models.DataType) *gorm.DB {
+	return s.Db.Model(&models.Activity{}).
+		Select("id").
+		Where("user_id = ? AND data_type = ?", userID, dataType)
+}
+
+// DeleteSourceData permanently removes everything stored for one source of a user, it is meant to
+// be called inside a Transaction.
+func (s *Postgres) DeleteSourceData(ctx context.Context, userID uuid.UUID, dataType models.DataType) (*models.SourcePurge, error) {
+	purge := &models.SourcePurge{Source: dataType}
+
+	deletes := []struct {
+		count *int64
+		query *gorm.DB
+		model interface{}
+	}{
+		// the key goes first, its row lock makes imports holding LockDataKey finish before anything else is deleted
+		{&purge.DataKeys, s.Db.Where("user_id = ? AND data_type = ?", userID, dataType), &models.DataKey{}},
+		{&purge.Identifiers, s.Db.Where("activity_id IN (?)", s.sourceActivityIDs(userID, dataType)), &models.Identifier{}},
+		{&purge.OrderItems, s.Db.Where("activity_id IN (?)", s.sourceActivityIDs(userID, dataType)), &models.OrderItem{}},
+		{&purge.Episodes, s.Db.Where("activity_id IN (?)", s.sourceActivityIDs(userID, dataType)), &models.Episode{}},
+		{&purge.Activities, s.Db.Where("user_id = ? AND data_type = ?", userID, dataType), &models.Activity{}},
+		{&purge.ActivityStats, s.Db.Where("user_id = ? AND data_type = ?", userID, dataType), &models.ActivityStat{}},
+		{&purge.Traits, s.Db.Where("user_id = ? AND data_type = ?", userID, dataType), &models.Trait{}},
+		{&purge.SyncRuns, s.Db.Where("user_id = ? AND data_type = ?", userID, dataType), &models.SyncRun{}},
+	}
+
+	for _, d := range deletes {
+		tx := d.query.Unscoped().Delete(d.model)
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+		*d.count = tx.RowsAffected
+	}
+
+	return purge, nil
+}
+
+// GetStatDiscrepancies compares every stored monthly stat total with a count of the processed activities it covers.
+func (s *Postgres) GetStatDiscrepancies(ctx context.Context) ([]models.StatDiscrepancy, error) {
+	var discrepancies []models.StatDiscrepancy
+
+	tx := s.Db.Raw(`
+		WITH counts AS (
+			SELECT user_id, data_type, COALESCE(content_type, '') AS content_type,
+				EXTRACT(YEAR FROM date AT TIME ZONE 'UTC')::int AS year,
+				EXTRACT(MONTH FROM date AT TIME ZONE 'UTC')::int AS month,
+				COUNT(*) AS total
+			FROM activities
+			WHERE processed AND deleted_at IS NULL AND ` + countedActivities + `
+			GROUP BY 1, 2, 3, 4, 5
+		), stats AS (
+			SELECT user_id, data_type, COALESCE(content_type, '') AS content_type, year, month, SUM(total) AS total
+			FROM activity_stats
+			WHERE deleted_at IS NULL
+			GROUP BY 1, 2, 3, 4, 5
+		)
+		SELECT
+			COALESCE(c.user_id, s.user_id) AS user_id,
+			COALESCE(c.data_type, s.data_type) AS data_type,
+			COALESCE(c.content_type, s.content_type) AS content_type,
+			COALESCE(c.year, s.year) AS year,
+			COALESCE(c.month, s.month) AS month,
+			COALESCE(c.total, 0) AS activities,
+			COALESCE(s.total, 0) AS stat_total
+		FROM counts c
+		FULL OUTER JOIN stats s
+			ON c.user_id = s.user_id AND c.data_type = s.data_type AND c.content_type = s.content_type
+			AND c.year = s.year AND c.month = s.month
+		WHERE COALESCE(c.total, 0) <> COALESCE(s.total, 0)
+		ORDER BY 1, 2, 3, 4, 5`).
+		Scan(&discrepancies)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return discrepancies, nil
+}
+
+// GetStuckUnprocessedActivities counts per user the activities still unprocessed that were stored before stuckBefore.
+func (s *Postgres) GetStuckUnprocessedActivities(ctx context.Context, stuckBefore time.Time) ([]models.StuckActivities, error) {
+	var stuck []models.StuckActivities
+
+	tx := s.Db.Model(&models.Activity{}).
+		Select("user_id, COUNT(*) AS count, MIN(created_at) AS oldest_since").
+		Where("processed = ? AND created_at < ?", false, stuckBefore).
+		Group("user_id").
+		Order("user_id").
+		Scan(&stuck)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return stuck, nil
+}
+
+// GetViewingsByUser returns the date and title of a user's activities from the given sources, oldest first.
+func (s *Postgres) GetViewingsByUser(ctx context.Context, userID uuid.UUID, dataTypes []models.DataType) ([]models.Activity, error) {
+	var activities []models.Activity
+
+	tx := s.Db.Model(&models.Activity{}).
+		Select("data_type, title, date").
+		Where("user_id = ? AND data_type IN ?", userID, dataTypes).
+		Order("date").
+		Find(&activities)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return activities, nil
+}
+
+// DeleteViewingHabits removes a user's streak and binge sessions and returns how many of each were removed.
+func (s *Postgres) DeleteViewingHabits(ctx context.Context, userID uuid.UUID) (int64, int64, error) {
+	tx := s.Db.Unscoped().Where("user_id = ?", userID).Delete(&models.ViewingStreak{})
+	if tx.Error != nil {
+		return 0, 0, tx.Error
+	}
+	streaks := tx.RowsAffected
+
+	tx = s.Db.Unscoped().Where("user_id = ?", userID).Delete(&models.BingeSession{})
+	if tx.Error != nil {
+		return 0, 0, tx.Error
+	}
+	return streaks, tx.RowsAffected, nil
+}
+
+// ReplaceViewingHabits swaps a user's streak and binge sessions for freshly computed ones, it is meant to be
+// called inside a Transaction.
+func (s *Postgres) ReplaceViewingHabits(ctx context.Context, userID uuid.UUID, streak *models.ViewingStreak, binges []*models.BingeSession) error {
+	if _, _, err := s.DeleteViewingHabits(ctx, userID); err != nil {
+		return err
+	}
+
+	if err := s.Db.Create(streak).Error; err != nil {
+		return err
+	}
+	if len(binges) == 0 {
+		return nil
+	}
+	return s.Db.Create(&binges).Error
+}
+
+func (s *Postgres) GetViewingStreakByUser(ctx context.Context, userID uuid.UUID) (*models.ViewingStreak, error) {
+	var streaks []models.ViewingStreak
+
+	tx := s.Db.Model(&models.ViewingStreak{}).
+		Where("user_id = ?", userID).
+		Limit(1).
+		Find(&streaks)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if len(streaks) == 0 {
+		return nil, nil
+	}
+	return &streaks[0], nil
+}
+
+// GetBingeSessionsByUser returns a user's binge sessions, most recent first.
+func (s *Postgres) GetBingeSessionsByUser(ctx context.Context, userID uuid.UUID, limit int) ([]models.BingeSession, error) {
+	binges := make([]models.BingeSession, 0)
+
+	tx := s.Db.Model(&models.BingeSession{}).
+		Where("user_id = ?", userID).
+		Order("date DESC, episodes DESC").
+		Limit(limit).
+		Find(&binges)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return binges, nil
+}
+
+// GetNetflixActivitiesWithoutEpisode returns netflix activities whose title has not been parsed yet.
+func (s *Postgres) GetNetflixActivitiesWithoutEpisode(ctx context.Context, userID uuid.UUID, limit int) ([]models.Activity, error) {
+	var activities []models.Activity
+
+	tx := s.Db.Model(&models.Activity{}).
+		Select("activities.id, activities.title, activities.date").
+		Joins("LEFT JOIN episodes ON episodes.activity_id = activities.id").
+		Where("activities.user_id = ? AND activities.data_type = ? AND episodes.id IS NULL", userID, models.DataTypeNetflix).
+		Order("activities.date").
+		Limit(limit).
+		Find(&activities)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return activities, nil
+}
+
+// FindOrCreateSeries returns the ids of the series with the given titles, creating the missing ones.
+func (s *Postgres) FindOrCreateSeries(ctx context.Context, titles []string) (map[string]uuid.UUID, error) {
+	ids := make(map[string]uuid.UUID)
+	if len(titles) == 0 {
+		return ids, nil
+	}
+
+	series := make([]*models.Series, 0, len(titles))
+	for _, title := range titles {
+		series = append(series, &models.Series{Title: title})
+	}
+
+	tx := s.Db.Clauses(clause.OnConflict{DoNothing: true}).Model(&models.Series{}).Create(&series)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	var stored []models.Series
+	tx = s.Db.Model(&models.Series{}).Where("title IN ?", titles).Find(&stored)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	for _, series := range stored {
+		ids[series.Title] = series.ID
+	}
+	return ids, nil
+}
+
+func (s *Postgres) CreateEpisodes(ctx context.Context, episodes []*models.Episode) error {
+	return s.Db.Clauses(clause.OnConflict{DoNothing: true}).Model(&models.Episode{}).Create(&episodes).Error
+}
+
+func (s *Postgres) GetTopSeriesByUser(ctx context.Context, userID uuid.UUID, limit int) ([]models.SeriesStat, error) {
+	var stats []models.SeriesStat
+
+	tx := s.Db.Model(&models.Episode{}).
+		Select("series.title AS series, COUNT(*) AS episodes, COUNT(DISTINCT episodes.season_number) AS seasons, MAX(episodes.watched_at) AS last_watched").
+		Joins("JOIN series ON series.id = episodes.series_id").
+		Where("episodes.user_id = ? AND episodes.kind = ?", userID, "episode").
+		Group("series.title").
+		Order("episodes DESC, last_watched DESC").
+		Limit(limit).
+		Scan(&stats)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return stats, nil
+}
+
+func (s *Postgres) GetCompletedSeasonsByUser(ctx context.Context, userID uuid.UUID) ([]models.CompletedSeason, error) {
+	var seasons []models.CompletedSeason
+
+	tx := s.Db.Raw(`
+		WITH seasons AS (
+			SELECT user_id, series_id, season_number, MAX(season) AS season,
+				COUNT(DISTINCT name) AS episodes,
+				MAX(watched_at) AS finished_at
+			FROM episodes
+			WHERE kind = @kind AND season_number > 0 AND deleted_at IS NULL
+				AND series_id IN (SELECT series_id FROM episodes WHERE user_id = @user AND kind = @kind)
+			GROUP BY user_id, series_id, season_number
+		), known AS (
+			SELECT series_id, season_number, MAX(episodes) AS known_episodes,
+				MAX(season_number) OVER (PARTITION BY series_id) AS final_season
+			FROM seasons
+			GROUP BY series_id, season_number
+		), finishers AS (
+			SELECT seasons.series_id, seasons.season_number, COUNT(*) AS users
+			FROM seasons
+			JOIN known ON known.series_id = seasons.series_id AND known.season_number = seasons.season_number
+			WHERE seasons.episodes = known.known_episodes
+			GROUP BY 1, 2
+		), watched AS (
+			SELECT *, MAX(season_number) OVER (PARTITION BY series_id) AS latest_season
+			FROM seasons
+			WHERE user_id = @user
+		)
+		SELECT series.title AS series, watched.season, watched.season_number, watched.episodes,
+			known.known_episodes, watched.finished_at
+		FROM watched
+		JOIN known ON known.series_id = watched.series_id AND known.season_number = watched.season_number
+		JOIN finishers ON finishers.series_id = watched.series_id AND finishers.season_number = watched.season_number
+		JOIN series ON series.id = watched.series_id
+		WHERE watched.episodes >= known.known_episodes AND (
+			watched.season_number < watched.latest_season
+			OR (watched.season_number = known.final_season AND finishers.users > 1)
+		)
+		ORDER BY watched.finished_at DESC`,
+		sql.Named("user", userID), sql.Named("kind", "episode")).
+		Scan(&seasons)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return seasons, nil
+}
+
+func (s *Postgres) GetTitleSplitByUser(ctx context.Context, userID uuid.UUID) (*models.TitleSplit, error) {
+	var counts []struct {
+		Kind   string
+		Count  int
+		Series int
+	}
+
+	tx := s.Db.Model(&models.Episode{}).
+		Select("kind, COUNT(*) AS count, COUNT(DISTINCT series_id) AS series").
+		Where("user_id = ?", userID).
+		Group("kind").
+		Scan(&counts)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	split := &models.TitleSplit{}
+	for _, count := range counts {
+		switch count.Kind {
+		case "movie":
+			split.Movies = count.Count
+		case "episode":
+			split.Episodes = count.Count
+			split.Series = count.Series
+		default:
+			split.Unparsed += count.Count
+		}
+	}
+	return split, nil
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"gandalf-data-aggregator/models"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestActivityChildrenSkipsDuplicates(t *testing.T) {
+	stored := &models.Activity{
+		Base:    models.Base{ID: uuid.New()},
+		Subject: []models.Identifier{{Value: "order-1", IdentifierType: "INSTACART"}},
+		Items:   []models.OrderItem{{ItemID: "item-1"}, {ItemID: "item-2"}},
+	}
+	duplicate := &models.Activity{
+		Base:    models.Base{ID: uuid.New()},
+		Subject: []models.Identifier{{Value: "order-2", IdentifierType: "INSTACART"}},
+		Items:   []models.OrderItem{{ItemID: "item-3"}},
+	}
+	inserted := &models.Activity{
+		Base:    models.Base{ID: uuid.New()},
+		Subject: []models.Identifier{{Value: "order-3", IdentifierType: "INSTACART"}},
+		Items:   []models.OrderItem{{ItemID: "item-4"}},
+	}
+
+	// a replayed page where the first two activities were already stored
+	identifiers, items := activityChildren([]*models.Activity{stored, duplicate, inserted}, []uuid.UUID{inserted.ID})
+
+	if len(identifiers) != 1 || identifiers[0].Value != "order-3" || identifiers[0].ActivityID != inserted.ID {
+		t.Errorf("identifiers = %+v, want only order-3 of the inserted activity", identifiers)
+	}
+	if len(items) != 1 || items[0].ItemID != "item-4" || items[0].ActivityID != inserted.ID {
+		t.Errorf("items = %+v, want only item-4 of the inserted activity", items)
+	}
+}
+
+func TestActivityChildrenPointAtTheirActivity(t *testing.T) {
+	first := &models.Activity{
+		Base:  models.Base{ID: uuid.New()},
+		Items: []models.OrderItem{{ItemID: "a"}, {ItemID: "b"}},
+	}
+	second := &models.Activity{
+		Base:  models.Base{ID: uuid.New()},
+		Items: []models.OrderItem{{ItemID: "c"}},
+	}
+
+	_, items := activityChildren([]*models.Activity{first, second}, []uuid.UUID{second.ID, first.ID})
+
+	want := map[string]uuid.UUID{"a": first.ID, "b": first.ID, "c": second.ID}
+	if len(items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(items), len(want))
+	}
+	for _, item := range items {
+		if item.ActivityID != want[item.ItemID] {
+			t.Errorf("item %s points at %s, want %s", item.ItemID, item.ActivityID, want[item.ItemID])
+		}
+	}
+}
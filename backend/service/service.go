@@ -2,21 +2,33 @@ package service
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"gandalf-data-aggregator/config"
+	"gandalf-data-aggregator/mapper"
 	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/pkg/crypto"
 	token "gandalf-data-aggregator/pkg/jwt"
+	"gandalf-data-aggregator/pkg/money"
+	"gandalf-data-aggregator/progress"
 	"gandalf-data-aggregator/repository"
 	"gandalf-data-aggregator/store"
 	"gandalf-data-aggregator/webapi/eyeofsauron"
 	workertask "gandalf-data-aggregator/worker/tasks"
+	"math"
+	"math/rand"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gandalf-network/gandalf-sdk-go/eyeofsauron/graphqlTypes"
 	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
 
 	"github.com/markbates/goth/providers/twitter"
 )
@@ -24,15 +36,19 @@ import (
 type Service struct {
 	twitterProvider *twitter.Provider
 	repo            *repository.Postgres
-	gandalfClient   *eyeofsauron.EyeOfSauron
+	gandalfClient   *eyeofsauron.RateLimitedClient
 	sessionStore    *store.SessionStore
 	jwtMaker        token.Maker
 	wt              workertask.WorkerTask
+	mappers         *mapper.Registry
+	broker          progress.Broker
+	envelope        *crypto.Envelope
+	activityStore   activityStore
 	cfg             config.Config
 }
 
-func NewService(cfg config.Config, repo *repository.Postgres, gandalClient *eyeofsauron.EyeOfSauron, sessionStore *store.SessionStore, workertask workertask.WorkerTask, jwtMaker token.Maker) *Service {
-	return &Service{
+func NewService(cfg config.Config, repo *repository.Postgres, gandalClient *eyeofsauron.RateLimitedClient, sessionStore *store.SessionStore, workertask workertask.WorkerTask, jwtMaker token.Maker, broker progress.Broker, envelope *crypto.Envelope) *Service {
+	s := &Service{
 		twitterProvider: twitter.New(cfg.Twitter.Key, cfg.Twitter.Secret, cfg.Twitter.Callback),
 		repo:            repo,
 		gandalfClient:   gandalClient,
@@ -40,7 +56,12 @@ func NewService(cfg config.Config, repo *repository.Postgres, gandalClient *eyeo
 		sessionStore:    sessionStore,
 		jwtMaker:        jwtMaker,
 		wt:              workertask,
+		mappers:         mapper.NewDefaultRegistry(),
+		broker:          broker,
+		envelope:        envelope,
 	}
+	s.activityStore = postgresActivityStore{service: s}
+	return s
 }
 
 func (s Service) BeginAuth(ctx context.Context) (string, error) {
@@ -112,20 +133,52 @@ func (s Service) CompleteAuth(ctx context.Context, rawAuthURL string) (*models.U
 	return user, token, nil
 }
 
-func (s Service) GenerateGandalfCallback(ctx context.Context, userID uuid.UUID) (string, error) {
+func (s Service) GenerateGandalfCallback(ctx context.Context, userID uuid.UUID, dataType models.DataType) (string, error) {
 	state := uuid.NewString()
 	state, err := s.sessionStore.FindKeyOrSaveSession(state, userID.String())
 	if err != nil {
 		return "", err
 	}
-	callbackURL := fmt.Sprintf("%s/gandalf/callback/netflix/%s", s.cfg.ServerURL, state)
+	callbackURL := fmt.Sprintf("%s/gandalf/callback/%s/%s", s.cfg.ServerURL, dataType, state)
 	return callbackURL, nil
 }
 
+var (
+	ErrUnknownCallbackState = errors.New("no active session for state")
+	ErrInvalidDataKey       = errors.New("gandalf did not accept the data key")
+)
+
+// verifyDataKey makes sure a data key handed to the callback was issued by gandalf for this app and
+// source before it is stored, so forged keys never reach the resolver.
+func (s Service) verifyDataKey(ctx context.Context, key string, dataType models.DataType) error {
+	if key == "" {
+		return fmt.Errorf("%w: missing data key", ErrInvalidDataKey)
+	}
+
+	sourceMapper, err := s.mappers.LookupDataType(dataType)
+	if err != nil {
+		return err
+	}
+
+	err = s.gandalfClient.VerifyDataKey(ctx, key, sourceMapper.Source())
+	if errors.Is(err, eyeofsauron.ErrPermanent) {
+		return fmt.Errorf("%w: %v", ErrInvalidDataKey, err)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to verify data key: %w", err)
+	}
+	return nil
+}
+
 func (s Service) RegisterUserDataKey(ctx context.Context, state string, key string, source string) error {
+	dataType, err := models.ParseDataType(source)
+	if err != nil {
+		return err
+	}
+
 	sessionUserID, err := s.sessionStore.GetSession(state)
 	if err != nil {
-		return fmt.Errorf("no active session for state: %s", state)
+		return fmt.Errorf("%w: %s", ErrUnknownCallbackState, state)
 	}
 
 	userID, err := uuid.Parse(sessionUserID)
@@ -133,115 +186,600 @@ func (s Service) RegisterUserDataKey(ctx context.Context, state string, key stri
 		return err
 	}
 
+	if err := s.verifyDataKey(ctx, key, dataType); err != nil {
+		return err
+	}
+
+	sealedKey, err := s.envelope.Seal(key)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt data key: %w", err)
+	}
+
 	dataKey := &models.DataKey{
 		UserID:   userID,
-		DataType: models.DataType(source),
-		Key:      key,
+		DataType: dataType,
+		Key:      sealedKey,
 	}
 
-	_, err = s.repo.FindOrCreateDataKey(ctx, dataKey)
+	dataKey, err = s.repo.FindOrCreateDataKey(ctx, dataKey)
 	if err != nil {
 		return err
 	}
 
+	// reconnecting a source hands us a fresh key, so one rejected earlier can be synced again
+	if !dataKey.Active() {
+		if err := s.repo.ReactivateDataKey(ctx, dataKey); err != nil {
+			return err
+		}
+	}
+
 	err = s.wt.EnqueueActivityDataResolver(workertask.QueuePayload{
-		UserID:  userID,
-		DataKey: key,
+		UserID:   userID,
+		DataType: dataKey.DataType,
 	})
 
 	if err != nil {
 		log.Error().Err(err).Msg("EnqueueActivityDataResolver failed")
 	}
 
+	err = s.wt.EnqueueTraitsResolver(workertask.QueuePayload{
+		UserID:   userID,
+		DataType: dataKey.DataType,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("EnqueueTraitsResolver failed")
+	}
+
 	return nil
 }
 
-func (s *Service) GetActivitySetByUser(ctx context.Context, userID uuid.UUID, limit int, page int) (*models.ActivityDataSet, error) {
-	return s.repo.GetActivitySetByUser(ctx, userID, limit, page)
+func (s *Service) GetActivitySetByUser(ctx context.Context, userID uuid.UUID, dataType models.DataType, limit int, page int) (*models.ActivityDataSet, error) {
+	return s.repo.GetActivitySetByUser(ctx, userID, dataType, limit, page)
 }
 
 func (s *Service) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
 	return s.repo.GetUserByID(ctx, userID)
 }
 
-func (s *Service) FetchAndDumpUserActivities(ctx context.Context, userID uuid.UUID, dataKey string) error {
-	var limit int64 = 300
-	totalCount, _ := s.repo.GetTotalActivitiesByUser(ctx, userID)
+// FetchResult summarises a single import run.
+type FetchResult struct {
+	Pages         int
+	Fetched       int
+	Mapped        int
+	Inserted      int
+	Duplicates    int
+	ParseFailures int
+	// Total is the activity count gandalf reported for the data key
+	Total int64
+	// Unmapped counts activities by metadata typename that no mapper could convert
+	Unmapped map[string]int
+}
+
+// FetchAndDumpUserActivities imports the activities of a linked data key. An initial import plans every page
+// from the first response's total and fetches them concurrently, resuming a failed import after the last run
+// of pages it fully persisted. Once it has completed, later runs only read what was added since, see
+// fetchNewActivities.
+func (s *Service) FetchAndDumpUserActivities(ctx context.Context, userID uuid.UUID, dataType models.DataType) (*FetchResult, error) {
+	sourceMapper, err := s.mappers.LookupDataType(dataType)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := s.repo.GetDataKey(ctx, userID, dataType)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSourceNotLinked
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !dataKey.Active() {
+		return nil, ErrDataKeyInactive
+	}
+
+	key, err := s.openDataKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	source := &activitySource{dataKey: dataKey, key: key, mapper: sourceMapper}
+
+	result, err := s.importActivities(ctx, source, 300)
+	if err != nil {
+		s.deactivateRejectedDataKey(ctx, dataKey, err)
+		return result, err
+	}
+
+	if len(result.Unmapped) > 0 {
+		log.Warn().Interface("unmapped", result.Unmapped).Msgf("Skipped activities with no %s mapper", sourceMapper.Source())
+	}
+	return result, nil
+}
+
+// importActivities imports source in pages of limit activities and records how far the import got.
+func (s *Service) importActivities(ctx context.Context, source *activitySource, limit int64) (*FetchResult, error) {
+	dataKey := source.dataKey
+	result := &FetchResult{Unmapped: make(map[string]int)}
+
+	var firstActivityID string
 	var page int64
-	if totalCount <= limit {
-		page = 1
-	} else if totalCount%limit == 0 {
-		page = (totalCount / limit)
+	var err error
+	if dataKey.LastSyncedAt != nil {
+		firstActivityID, page, err = s.fetchNewActivities(ctx, source, limit, result)
 	} else {
-		page = (totalCount / limit) + 1
+		var start int64 = 1
+		if dataKey.LastPage > 0 {
+			start = dataKey.LastPage + 1
+		}
+		firstActivityID, page, err = s.fetchAllActivities(ctx, source, start, limit, result)
+	}
+	if err != nil {
+		return result, err
 	}
 
-	for {
-		activityResponse, err := s.gandalfClient.GetActivity(context.Background(), dataKey, eyeofsauron.SourceNetflix, graphqlTypes.Int64(limit), graphqlTypes.Int64(page))
+	syncedAt := time.Now()
+	if firstActivityID != "" {
+		dataKey.LastActivityID = firstActivityID
+	}
+	dataKey.LastPage = page
+	dataKey.Total = result.Total
+	dataKey.LastSyncedAt = &syncedAt
+	if err := s.activityStore.saveSyncState(ctx, dataKey); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// ErrDataKeyInactive is returned for syncs of a data key gandalf rejected, it is permanent until the user reconnects.
+var ErrDataKeyInactive = fmt.Errorf("%w: data key is inactive", eyeofsauron.ErrPermanent)
+
+// deactivateRejectedDataKey stops syncing a data key once gandalf rejects it, so the user is asked to reconnect
+// instead of the import retrying until it is archived.
+func (s *Service) deactivateRejectedDataKey(ctx context.Context, dataKey *models.DataKey, err error) {
+	if !errors.Is(err, eyeofsauron.ErrDataKeyRejected) {
+		return
+	}
+
+	if err := s.repo.DeactivateDataKey(ctx, dataKey, err.Error()); err != nil {
+		log.Error().Err(err).Msgf("Unable to deactivate data key %s", dataKey.ID)
+		return
+	}
+	log.Warn().Msgf("Deactivated %s data key %s for user %s: %s", dataKey.DataType, dataKey.ID, dataKey.UserID, dataKey.InactiveReason)
+}
+
+// GetSourcesNeedingReconnect lists the sources of a user whose data key gandalf has rejected.
+func (s *Service) GetSourcesNeedingReconnect(ctx context.Context, userID uuid.UUID) ([]models.DataType, error) {
+	dataKeys, err := s.repo.GetDataKeysByUser(ctx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]models.DataType, 0)
+	for _, dataKey := range dataKeys {
+		if !dataKey.Active() {
+			sources = append(sources, dataKey.DataType)
+		}
+	}
+	return sources, nil
+}
+
+// activitySource is a data key being imported, with its key decrypted for the duration of the import.
+type activitySource struct {
+	dataKey *models.DataKey
+	key     string
+	mapper  mapper.SourceMapper
+}
+
+// activityStore persists what an import fetched, tests swap the database for memory.
+type activityStore interface {
+	// storeActivities inserts the activities that are not stored yet and returns how many it inserted
+	storeActivities(ctx context.Context, dataKey *models.DataKey, activities []*models.Activity) (int64, error)
+	saveSyncState(ctx context.Context, dataKey *models.DataKey) error
+}
+
+// postgresActivityStore writes imported activities only while their source is linked, see whileLinked.
+type postgresActivityStore struct {
+	service *Service
+}
+
+func (p postgresActivityStore) storeActivities(ctx context.Context, dataKey *models.DataKey, activities []*models.Activity) (int64, error) {
+	var inserted int64
+	err := p.service.whileLinked(ctx, dataKey.UserID, dataKey.DataType, func(ctx context.Context, tx *repository.Postgres) error {
+		var err error
+		inserted, err = tx.CreateActivities(ctx, activities)
+		return err
+	})
+	return inserted, err
+}
+
+func (p postgresActivityStore) saveSyncState(ctx context.Context, dataKey *models.DataKey) error {
+	return p.service.repo.UpdateDataKeySyncState(ctx, dataKey)
+}
+
+// openDataKey decrypts a data key for a call to gandalf. Keys stored before encryption was introduced
+// are used as is until the rotation command seals them.
+func (s *Service) openDataKey(dataKey *models.DataKey) (string, error) {
+	if !crypto.IsSealed(dataKey.Key) {
+		log.Warn().Msgf("Data key %s is stored unencrypted, run the data key rotation", dataKey.ID)
+		return dataKey.Key, nil
+	}
+
+	key, err := s.envelope.Open(dataKey.Key)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt data key %s: %w", dataKey.ID, err)
+	}
+	return key, nil
+}
+
+// RotateDataKeyEncryption seals plaintext data keys and rewraps those sealed under a previous master key
+// with the current one. It returns how many keys were rewritten.
+func (s *Service) RotateDataKeyEncryption(ctx context.Context, batchSize int) (int, error) {
+	rotated := 0
+	for offset := 0; ; offset += batchSize {
+		dataKeys, err := s.repo.ListDataKeys(ctx, offset, batchSize)
 		if err != nil {
-			log.Error().Err(err).Msg("QueryActivities on gandalf failed.")
-			return err
+			return rotated, err
+		}
+
+		for i := range dataKeys {
+			dataKey := &dataKeys[i]
+			if !s.envelope.NeedsRotation(dataKey.Key) {
+				continue
+			}
+
+			dataKey.Key, err = s.envelope.Rotate(dataKey.Key)
+			if err != nil {
+				return rotated, fmt.Errorf("unable to rotate data key %s: %w", dataKey.ID, err)
+			}
+			if err := s.repo.UpdateDataKeyKey(ctx, dataKey); err != nil {
+				return rotated, err
+			}
+			rotated++
 		}
 
-		if len(activityResponse.GetActivity.Data) == 0 {
+		if len(dataKeys) < batchSize {
+			return rotated, nil
+		}
+	}
+}
+
+// pageImport is the outcome of importing a single page of activities.
+type pageImport struct {
+	FetchResult
+	firstActivityID  string
+	reachedKnownData bool
+}
+
+func (r *FetchResult) merge(page *FetchResult) {
+	r.Pages += page.Pages
+	r.Fetched += page.Fetched
+	r.Mapped += page.Mapped
+	r.Inserted += page.Inserted
+	r.Duplicates += page.Duplicates
+	r.ParseFailures += page.ParseFailures
+	if page.Total > 0 {
+		r.Total = page.Total
+	}
+	for typename, count := range page.Unmapped {
+		r.Unmapped[typename] += count
+	}
+}
+
+// importPage fetches, maps and stores a single page, stopping at stopAtActivityID when it is set.
+// Activities that are already stored are skipped so pages can be imported in any order.
+func (s *Service) importPage(ctx context.Context, source *activitySource, limit, page int64, stopAtActivityID string) (*pageImport, error) {
+	dataKey, sourceMapper := source.dataKey, source.mapper
+	activityResponse, err := s.gandalfClient.GetActivity(ctx, source.key, sourceMapper.Source(), graphqlTypes.Int64(limit), graphqlTypes.Int64(page))
+	if err != nil {
+		log.Error().Err(err).Msgf("QueryActivities on gandalf failed for page %d.", page)
+		return nil, err
+	}
+
+	imported := &pageImport{FetchResult: FetchResult{Unmapped: make(map[string]int)}}
+	imported.Total = int64(activityResponse.GetActivity.Total)
+	if len(activityResponse.GetActivity.Data) == 0 {
+		return imported, nil
+	}
+	imported.Pages = 1
+	imported.firstActivityID = activityResponse.GetActivity.Data[0].Id
+
+	var activities []*models.Activity
+	for _, activity := range activityResponse.GetActivity.Data {
+		if stopAtActivityID != "" && activity.Id == stopAtActivityID {
+			imported.reachedKnownData = true
 			break
 		}
+		imported.Fetched++
 
-		var activities []*models.Activity
-		for _, activity := range activityResponse.GetActivity.Data {
-			var identifiers []models.Identifier
+		mapped, err := sourceMapper.Map(dataKey.UserID, activity.Id, activity.GetMetadata())
+		if errors.Is(err, mapper.ErrUnexpectedMetadata) {
+			imported.Unmapped[activity.GetMetadata().GetTypename()]++
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("Unable to map %s activity %s", sourceMapper.Source(), activity.Id)
+			imported.ParseFailures++
+			continue
+		}
 
-			metadata := activity.GetMetadata()
+		imported.Mapped++
+		activities = append(activities, mapped)
+	}
 
-			switch meta := metadata.(type) {
-			case *eyeofsauron.GetActivityActivityResponseDataActivityMetadataNetflixActivityMetadata:
-				for _, identifier := range meta.Subject {
-					identifiers = append(identifiers, models.Identifier{
-						Value:          identifier.Value,
-						IdentifierType: string(identifier.IdentifierType),
-					})
-				}
+	if len(activities) > 0 {
+		inserted, err := s.activityStore.storeActivities(ctx, dataKey, activities)
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to create activities")
+			return nil, err
+		}
+		imported.Inserted = int(inserted)
+		imported.Duplicates = len(activities) - int(inserted)
+	}
 
-				date, err := time.Parse("01/02/2006", string(meta.Date))
-				if err != nil {
-					log.Error().Err(err).Msg("Unable to parse date")
-					continue
-				}
+	return imported, nil
+}
 
-				activities = append(activities, &models.Activity{
-					UserID:             userID,
-					ProviderActivityID: activity.Id,
-					Title:              meta.Title,
-					Date:               date,
-					Subject:            identifiers,
-				})
-			default:
-				continue
+// fetchNewActivities imports what was added since the last sync. Gandalf does not document the order it lists
+// activities in, so the first page tells where new activity went. While it still starts with the activity it
+// started with on the last sync, activities are appended and reading resumes at the last known page once the
+// total grew. Otherwise they are prepended and pages are read until that activity shows up again.
+func (s *Service) fetchNewActivities(ctx context.Context, source *activitySource, limit int64, result *FetchResult) (string, int64, error) {
+	dataKey := source.dataKey
+	knownTotal := dataKey.Total
+
+	var page int64 = 1
+	imported, err := s.importPage(ctx, source, limit, page, dataKey.LastActivityID)
+	if err != nil {
+		return "", page, err
+	}
+	result.merge(&imported.FetchResult)
+	if imported.Pages == 0 {
+		return "", page, nil
+	}
+	firstActivityID := imported.firstActivityID
+
+	if dataKey.LastActivityID == "" || firstActivityID != dataKey.LastActivityID {
+		for {
+			s.publishFetching(ctx, dataKey, page, limit, result.Total, imported.reachedKnownData)
+			if imported.reachedKnownData || page*limit >= result.Total {
+				return firstActivityID, page, nil
+			}
+
+			page++
+			imported, err = s.importPage(ctx, source, limit, page, dataKey.LastActivityID)
+			if err != nil {
+				return firstActivityID, page, err
+			}
+			result.merge(&imported.FetchResult)
+			if imported.Pages == 0 {
+				return firstActivityID, page, nil
 			}
 		}
+	}
 
-		if _, err := s.repo.CreateActivities(ctx, activities); err != nil {
-			log.Error().Err(err).Msg("Unable to create activities")
+	if result.Total <= knownTotal {
+		s.publishFetching(ctx, dataKey, page, limit, result.Total, true)
+		return firstActivityID, page, nil
+	}
+
+	// the page holding the last known activity may have room left, it is read again
+	if knownTotal > limit {
+		page = (knownTotal + limit - 1) / limit
+	}
+	for ; ; page++ {
+		imported, err = s.importPage(ctx, source, limit, page, "")
+		if err != nil {
+			return firstActivityID, page, err
+		}
+		result.merge(&imported.FetchResult)
+		if imported.Pages == 0 {
+			return firstActivityID, page, nil
+		}
+
+		s.publishFetching(ctx, dataKey, page, limit, result.Total, false)
+		if page*limit >= result.Total {
+			return firstActivityID, page, nil
+		}
+	}
+}
+
+// publishFetching reports the position of an incremental sync in the activity list, done means it has reached
+// the end of what is new.
+func (s *Service) publishFetching(ctx context.Context, dataKey *models.DataKey, page, limit, total int64, done bool) {
+	fetched := page * limit
+	if fetched > total || done {
+		fetched = total
+	}
+	s.publishProgress(ctx, progress.Event{
+		UserID:   dataKey.UserID,
+		DataType: dataKey.DataType,
+		Phase:    progress.PhaseFetching,
+		Fetched:  fetched,
+		Total:    total,
+		Page:     page,
+	})
+}
+
+// fetchAllActivities imports every page from start. The first page is read alone to learn the total,
+// the pages it plans are fetched by a bounded pool of workers that all share the gandalf rate limiter and
+// any past them one by one. Pages finish out of order, so the data key only records the last page before
+// which every page is stored.
+func (s *Service) fetchAllActivities(ctx context.Context, source *activitySource, start, limit int64, result *FetchResult) (string, int64, error) {
+	dataKey := source.dataKey
+	first, err := s.importPage(ctx, source, limit, start, "")
+	if err != nil {
+		return "", start, err
+	}
+	if first.Pages == 0 {
+		result.merge(&first.FetchResult)
+		return "", start, nil
+	}
+
+	var firstActivityID string
+	if start == 1 {
+		firstActivityID = first.firstActivityID
+	}
+
+	var mutex sync.Mutex
+	persisted := start - 1
+	completed := make(map[int64]bool)
+	resumedFrom := (start - 1) * limit
+
+	// pageDone must be called with mutex held
+	pageDone := func(page int64, imported *pageImport) error {
+		result.merge(&imported.FetchResult)
+		completed[page] = true
+		for completed[persisted+1] {
+			delete(completed, persisted+1)
+			persisted++
+		}
+
+		dataKey.LastPage = persisted
+		dataKey.Total = result.Total
+		if firstActivityID != "" {
+			dataKey.LastActivityID = firstActivityID
+		}
+		if err := s.activityStore.saveSyncState(ctx, dataKey); err != nil {
 			return err
 		}
-		page++
-		time.Sleep(2 * time.Second)
+
+		fetched := resumedFrom + int64(result.Fetched)
+		if fetched > result.Total {
+			fetched = result.Total
+		}
+		s.publishProgress(ctx, progress.Event{
+			UserID:   dataKey.UserID,
+			DataType: dataKey.DataType,
+			Phase:    progress.PhaseFetching,
+			Fetched:  fetched,
+			Total:    result.Total,
+			Page:     page,
+		})
+		return nil
 	}
 
-	return nil
+	if err := pageDone(start, first); err != nil {
+		return firstActivityID, persisted, err
+	}
+
+	concurrency := s.cfg.Sync.FetchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	lastPage := (result.Total + limit - 1) / limit
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(concurrency)
+	for page := start + 1; page <= lastPage; page++ {
+		if groupCtx.Err() != nil {
+			break
+		}
+
+		page := page
+		group.Go(func() error {
+			imported, err := s.importPage(groupCtx, source, limit, page, "")
+			if err != nil {
+				return err
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			return pageDone(page, imported)
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return firstActivityID, persisted, err
+	}
+
+	// activities added while the import ran push the last ones past the planned pages, so reading goes on
+	// until gandalf returns an empty page
+	page := lastPage + 1
+	if page <= start {
+		page = start + 1
+	}
+	for ; ; page++ {
+		imported, err := s.importPage(ctx, source, limit, page, "")
+		if err != nil {
+			return firstActivityID, persisted, err
+		}
+		if imported.Pages == 0 {
+			result.merge(&imported.FetchResult)
+			return firstActivityID, persisted, nil
+		}
+
+		mutex.Lock()
+		err = pageDone(page, imported)
+		mutex.Unlock()
+		if err != nil {
+			return firstActivityID, persisted, err
+		}
+	}
+}
+
+// EnqueueStaleDataKeySyncs queues an import and a traits refresh for every data key that has not synced within
+// the configured staleness, spreading them over the jitter window so they do not hit gandalf at once.
+func (s *Service) EnqueueStaleDataKeySyncs(ctx context.Context) (int, error) {
+	dataKeys, err := s.repo.GetStaleDataKeys(ctx, time.Now().Add(-s.cfg.Sync.Staleness))
+	if err != nil {
+		return 0, err
+	}
+
+	enqueued := 0
+	for _, dataKey := range dataKeys {
+		var delay time.Duration
+		if s.cfg.Sync.Jitter > 0 {
+			delay = time.Duration(rand.Int63n(int64(s.cfg.Sync.Jitter)))
+		}
+
+		payload := workertask.QueuePayload{
+			UserID:   dataKey.UserID,
+			DataType: dataKey.DataType,
+		}
+		err := s.wt.EnqueueActivityDataResolver(payload, asynq.ProcessIn(delay), asynq.Unique(s.cfg.Sync.Staleness))
+		if err != nil {
+			log.Error().Err(err).Msgf("Unable to enqueue resync for data key %s", dataKey.ID)
+			continue
+		}
+		enqueued++
+
+		// traits are kept as history, each resync adds a snapshot when gandalf reports a new value
+		err = s.wt.EnqueueTraitsResolver(payload, asynq.ProcessIn(delay), asynq.Unique(s.cfg.Sync.Staleness))
+		if err != nil {
+			log.Error().Err(err).Msgf("Unable to enqueue traits resync for data key %s", dataKey.ID)
+		}
+	}
+
+	return enqueued, nil
 }
 
-func (s *Service) GenerateActivityStats(ctx context.Context, userID uuid.UUID) error {
+// countsTowardStats leaves cancelled and unfulfilled trips out of the monthly totals and spend, they are still
+// marked processed. RebuildActivityStatsByUser applies the same rule in SQL.
+func countsTowardStats(activity *models.Activity) bool {
+	return activity.DataType != models.DataTypeUber || activity.Status == models.TripStatusCompleted
+}
+
+// statKey groups monthly counts per source, content type, currency and year.
+type statKey struct {
+	dataType    models.DataType
+	contentType string
+	currency    string
+	year        int
+}
+
+// GenerateActivityStats folds the unprocessed activities of a source into the monthly stats and returns how
+// many it processed. Passes of different sources read disjoint activities, so they can run side by side.
+func (s *Service) GenerateActivityStats(ctx context.Context, userID uuid.UUID, dataType models.DataType) (int, error) {
 	limit := 100
 	page := 1
+	processed := 0
 
 	var activitySet *models.ActivityDataSet
 	var err error
 
 	for {
-		activitySet, err = s.repo.FetchUnprocessedUserActivities(ctx, userID, limit, page)
+		activitySet, err = s.repo.FetchUnprocessedUserActivities(ctx, userID, dataType, limit, page)
 		if err != nil {
-			return nil
+			return processed, err
 		}
 
 		if len(activitySet.Data) == 0 {
@@ -249,64 +787,157 @@ func (s *Service) GenerateActivityStats(ctx context.Context, userID uuid.UUID) e
 		}
 
 		var activityIDSet uuid.UUIDs
-		currentYear, currentMonth := time.Now().Year(), int(time.Now().Month())
-		yearlyData := make(map[int][]int)
+		now := time.Now().UTC()
+		currentYear, currentMonth := now.Year(), int(now.Month())
+		yearlyData := make(map[statKey][]int)
+		yearlySpend := make(map[statKey]map[int]int64)
 
 		for _, record := range activitySet.Data {
-			year := record.Date.Year()
-			month := int(record.Date.Month())
+			// months are UTC, like the rebuild and the consistency check, whatever zone the worker runs in
+			date := record.Date.UTC()
+			key := statKey{dataType: record.DataType, contentType: record.ContentType, currency: record.Currency, year: date.Year()}
+			month := int(date.Month())
 			activityIDSet = append(activityIDSet, record.ID)
+			if !countsTowardStats(record) {
+				continue
+			}
 
-			if _, ok := yearlyData[year]; !ok {
-				if year == currentYear {
-					yearlyData[year] = make([]int, currentMonth)
+			if _, ok := yearlyData[key]; !ok {
+				if key.year == currentYear {
+					yearlyData[key] = make([]int, currentMonth)
 				} else {
-					yearlyData[year] = make([]int, 12)
+					yearlyData[key] = make([]int, 12)
 				}
-			} else if year == currentYear && len(yearlyData[year]) < currentMonth {
-				additionalMonths := make([]int, currentMonth-len(yearlyData[year]))
-				yearlyData[year] = append(yearlyData[year], additionalMonths...)
+			} else if key.year == currentYear && len(yearlyData[key]) < currentMonth {
+				additionalMonths := make([]int, currentMonth-len(yearlyData[key]))
+				yearlyData[key] = append(yearlyData[key], additionalMonths...)
 			}
 
-			yearlyData[year][month-1]++
+			yearlyData[key][month-1]++
+
+			if record.Amount != 0 {
+				if _, ok := yearlySpend[key]; !ok {
+					yearlySpend[key] = make(map[int]int64)
+				}
+				yearlySpend[key][month] += record.Amount
+			}
 		}
 
 		var stats []*models.ActivityStat
-		for year, months := range yearlyData {
-			fmt.Printf("<< Year: %d, Data: %v\n >> ", year, months)
+		for key, months := range yearlyData {
 			for month, count := range months {
 				stats = append(stats, &models.ActivityStat{
-					Year:   year,
-					Month:  month + 1,
-					Total:  count,
-					UserID: userID,
+					DataType:    key.dataType,
+					ContentType: key.contentType,
+					Currency:    key.currency,
+					Year:        key.year,
+					Month:       month + 1,
+					Total:       count,
+					Spend:       yearlySpend[key][month+1],
+					UserID:      userID,
 				})
 			}
 		}
 
 		if err = s.repo.Transaction(ctx, func(ctx context.Context, tx *repository.Postgres) error {
-			err = tx.BatchUpsertActivityStat(ctx, stats)
-			if err != nil {
-				return nil
-			}
-
-			err = tx.SetActivityStatsToProcessedByUser(ctx, activityIDSet)
-			if err != nil {
-				return nil
+			if err := tx.BatchUpsertActivityStat(ctx, stats); err != nil {
+				return err
 			}
 
-			return nil
+			return tx.SetActivityStatsToProcessedByUser(ctx, activityIDSet)
 		}); err != nil {
-			return err
+			return processed, err
 		}
+		processed += len(activityIDSet)
 		time.Sleep(2 * time.Second)
 	}
 	fmt.Println("<< STATS DONE >> ")
-	return nil
+	return processed, nil
+}
+
+// RebuildActivityStats recomputes a user's stats from their activities and swaps them in atomically. Unlike
+// GenerateActivityStats it is safe to re-run, so it repairs stats double counted by partial failures.
+// It returns how many activities the rebuilt stats cover.
+func (s *Service) RebuildActivityStats(ctx context.Context, userID uuid.UUID) (int, error) {
+	var counted int64
+	err := s.repo.Transaction(ctx, func(ctx context.Context, tx *repository.Postgres) error {
+		var err error
+		counted, err = tx.RebuildActivityStatsByUser(ctx, userID)
+		return err
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return 0, err
+	}
+	return int(counted), nil
+}
+
+// CheckStatsConsistency reports stats that disagree with the activities they count and activities that have
+// waited longer than stuckAfter for the stats task. With repair every affected user's stats are rebuilt.
+func (s *Service) CheckStatsConsistency(ctx context.Context, stuckAfter time.Duration, repair bool) (*models.StatsConsistencyReport, error) {
+	report := &models.StatsConsistencyReport{
+		CheckedAt:     time.Now(),
+		Discrepancies: make([]models.StatDiscrepancy, 0),
+		Stuck:         make([]models.StuckActivities, 0),
+		Repaired:      make([]uuid.UUID, 0),
+	}
+
+	discrepancies, err := s.repo.GetStatDiscrepancies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Discrepancies = append(report.Discrepancies, discrepancies...)
+
+	stuck, err := s.repo.GetStuckUnprocessedActivities(ctx, report.CheckedAt.Add(-stuckAfter))
+	if err != nil {
+		return nil, err
+	}
+	report.Stuck = append(report.Stuck, stuck...)
+
+	if !repair {
+		return report, nil
+	}
+
+	affected := make(map[uuid.UUID]bool)
+	var userIDs []uuid.UUID
+	for _, discrepancy := range report.Discrepancies {
+		if !affected[discrepancy.UserID] {
+			affected[discrepancy.UserID] = true
+			userIDs = append(userIDs, discrepancy.UserID)
+		}
+	}
+	for _, stuck := range report.Stuck {
+		if !affected[stuck.UserID] {
+			affected[stuck.UserID] = true
+			userIDs = append(userIDs, stuck.UserID)
+		}
+	}
+
+	for _, userID := range userIDs {
+		if _, err := s.RebuildActivityStats(ctx, userID); err != nil {
+			if report.Failed == nil {
+				report.Failed = make(map[string]string)
+			}
+			report.Failed[userID.String()] = err.Error()
+			continue
+		}
+		report.Repaired = append(report.Repaired, userID)
+	}
+
+	return report, nil
+}
+
+// GetUserIDsWithActivities lists the users that have stats to rebuild or check.
+func (s *Service) GetUserIDsWithActivities(ctx context.Context) ([]uuid.UUID, error) {
+	return s.repo.GetUserIDsWithActivities(ctx)
+}
+
+// EnqueueRebuildActivityStats hands a stats rebuild to the worker.
+func (s *Service) EnqueueRebuildActivityStats(userID uuid.UUID) error {
+	return s.wt.EnqueueRebuildActivityStats(workertask.QueuePayload{UserID: userID})
 }
 
-func (s *Service) GenerateUserYearlyData(ctx context.Context, userID uuid.UUID) (*models.YearDataStat, error) {
-	activityStats, err := s.repo.GetActivityStatsByUser(ctx, userID)
+func (s *Service) GenerateUserYearlyData(ctx context.Context, userID uuid.UUID, dataType models.DataType, contentType string) (*models.YearDataStat, error) {
+	activityStats, err := s.repo.GetActivityStatsByUser(ctx, userID, dataType, contentType)
 	if err != nil {
 		return nil, err
 	}
@@ -316,6 +947,7 @@ func (s *Service) GenerateUserYearlyData(ctx context.Context, userID uuid.UUID)
 		if _, ok := yearlyData[stat.Year]; !ok {
 			yearlyData[stat.Year] = models.YearData{
 				Labels: make([]int, 0),
+				Spend:  make(map[string][]float64),
 				Months: make([]string, 0),
 			}
 		}
@@ -326,6 +958,12 @@ func (s *Service) GenerateUserYearlyData(ctx context.Context, userID uuid.UUID)
 			copy(extendedLabels, yearData.Labels)
 			yearData.Labels = extendedLabels
 
+			for currency, spend := range yearData.Spend {
+				extendedSpend := make([]float64, stat.Month)
+				copy(extendedSpend, spend)
+				yearData.Spend[currency] = extendedSpend
+			}
+
 			extendedMonths := make([]string, stat.Month)
 			copy(extendedMonths, yearData.Months)
 			for i := len(yearData.Months); i < stat.Month; i++ {
@@ -334,13 +972,590 @@ func (s *Service) GenerateUserYearlyData(ctx context.Context, userID uuid.UUID)
 			yearData.Months = extendedMonths
 		}
 
-		yearData.Labels[stat.Month-1] = stat.Total
+		yearData.Labels[stat.Month-1] += stat.Total
+		if stat.Spend != 0 {
+			if _, ok := yearData.Spend[stat.Currency]; !ok {
+				yearData.Spend[stat.Currency] = make([]float64, len(yearData.Labels))
+			}
+			yearData.Spend[stat.Currency][stat.Month-1] += money.Amount{Value: stat.Spend, Currency: stat.Currency}.Float()
+		}
 		yearlyData[stat.Year] = yearData
 	}
 
+	streak, err := s.repo.GetViewingStreakByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	binges, err := s.repo.GetBingeSessionsByUser(ctx, userID, maxBingeSessions)
+	if err != nil {
+		return nil, err
+	}
+
 	currentYear := time.Now().Year()
 	return &models.YearDataStat{
 		YearData:    yearlyData,
 		CurrentYear: strconv.Itoa(currentYear),
+		Streak:      streak,
+		Binges:      binges,
 	}, nil
 }
+
+const dayLayout = "2006-01-02"
+
+// GenerateUserActivityHeatmap returns daily activity counts for a calendar heatmap along with totals per day of
+// the week. It covers the given year, or the year up to today when year is 0.
+func (s *Service) GenerateUserActivityHeatmap(ctx context.Context, userID uuid.UUID, dataType models.DataType, contentType string, year int) (*models.ActivityHeatmap, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(-1, 0, 1), today.AddDate(0, 0, 1)
+	if year != 0 {
+		from = time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		to = from.AddDate(1, 0, 0)
+	}
+
+	counts, err := s.repo.GetDailyActivityCountsByUser(ctx, userID, dataType, contentType, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	countByDay := make(map[string]int, len(counts))
+	for _, count := range counts {
+		countByDay[count.Date] = count.Count
+	}
+
+	heatmap := &models.ActivityHeatmap{
+		From:     from.Format(dayLayout),
+		To:       to.AddDate(0, 0, -1).Format(dayLayout),
+		Days:     make([]models.DayStat, 0, 366),
+		Weekdays: make([]models.WeekdayStat, 7),
+	}
+	for weekday := range heatmap.Weekdays {
+		heatmap.Weekdays[weekday] = models.WeekdayStat{
+			Weekday: weekday,
+			Day:     time.Weekday(weekday).String(),
+		}
+	}
+
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(dayLayout)
+		count := countByDay[date]
+
+		heatmap.Days = append(heatmap.Days, models.DayStat{Date: date, Count: count})
+		heatmap.Weekdays[day.Weekday()].Count += count
+		if count > heatmap.Max {
+			heatmap.Max = count
+		}
+	}
+
+	return heatmap, nil
+}
+
+// minorToUnits converts a spend summed in minor units of currency into whole units.
+func minorToUnits(spend float64, currency string) float64 {
+	return money.Amount{Value: int64(math.Round(spend)), Currency: currency}.Float()
+}
+
+func (s *Service) GenerateUserTripStats(ctx context.Context, userID uuid.UUID) (*models.TripStats, error) {
+	cities, err := s.repo.GetTripCityStatsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	months, err := s.repo.GetTripMonthStatsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range cities {
+		cities[i].Spend = minorToUnits(cities[i].Spend, cities[i].Currency)
+	}
+	for i := range months {
+		months[i].Spend = minorToUnits(months[i].Spend, months[i].Currency)
+	}
+
+	stats := &models.TripStats{
+		Cities: cities,
+		Months: months,
+	}
+	for _, city := range cities {
+		stats.TotalTrips += city.Trips
+		stats.TotalDistance += city.Distance
+	}
+
+	return stats, nil
+}
+
+func (s *Service) GenerateUserOrderStats(ctx context.Context, userID uuid.UUID, limit int) (*models.OrderStats, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+
+	products, err := s.repo.GetTopOrderedProductsByUser(ctx, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range products {
+		products[i].Spend = minorToUnits(products[i].Spend, products[i].Currency)
+	}
+
+	rates, err := s.repo.GetOrderItemRatesByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if rates.Total > 0 {
+		rates.ReplacedRate = float64(rates.Replaced) / float64(rates.Total)
+		rates.RefundRate = float64(rates.Refunded) / float64(rates.Total)
+	}
+
+	return &models.OrderStats{
+		TopProducts: products,
+		ItemRates:   *rates,
+	}, nil
+}
+
+// traitLabelsByDataType lists the gandalf traits worth asking each source for.
+var traitLabelsByDataType = map[models.DataType][]eyeofsauron.TraitLabel{
+	models.DataTypeNetflix:     {eyeofsauron.TraitLabelPlan, eyeofsauron.TraitLabelAccountCreatedOn},
+	models.DataTypePlaystation: {eyeofsauron.TraitLabelAccountCreatedOn},
+	models.DataTypeYoutube:     {eyeofsauron.TraitLabelFollowerCount, eyeofsauron.TraitLabelAccountCreatedOn},
+	models.DataTypeAmazon:      {eyeofsauron.TraitLabelPrimeSubscriber, eyeofsauron.TraitLabelAccountCreatedOn},
+	models.DataTypeUber:        {eyeofsauron.TraitLabelRating, eyeofsauron.TraitLabelTripCount, eyeofsauron.TraitLabelAccountCreatedOn},
+	models.DataTypeInstacart:   {eyeofsauron.TraitLabelAccountCreatedOn},
+}
+
+// FetchAndDumpUserTraits pulls traits for every data key the user linked, or only the given data type when set.
+func (s *Service) FetchAndDumpUserTraits(ctx context.Context, userID uuid.UUID, dataType models.DataType) error {
+	dataKeys, err := s.repo.GetDataKeysByUser(ctx, userID, dataType)
+	if err != nil {
+		return err
+	}
+
+	for _, dataKey := range dataKeys {
+		labels, ok := traitLabelsByDataType[dataKey.DataType]
+		if !ok || !dataKey.Active() {
+			continue
+		}
+
+		sourceMapper, err := s.mappers.LookupDataType(dataKey.DataType)
+		if err != nil {
+			return err
+		}
+
+		key, err := s.openDataKey(&dataKey)
+		if err != nil {
+			return err
+		}
+
+		traitsResponse, err := s.gandalfClient.GetTraits(ctx, key, sourceMapper.Source(), labels)
+		if err != nil {
+			log.Error().Err(err).Msg("QueryTraits on gandalf failed.")
+			s.deactivateRejectedDataKey(ctx, &dataKey, err)
+			return err
+		}
+
+		var traits []*models.Trait
+		for _, trait := range traitsResponse.GetTraits {
+			traits = append(traits, &models.Trait{
+				UserID:          userID,
+				DataType:        dataKey.DataType,
+				Label:           string(trait.Label),
+				Timestamp:       trait.Timestamp,
+				ProviderTraitID: trait.Id.String(),
+				Value:           trait.Value,
+			})
+		}
+
+		if len(traits) == 0 {
+			continue
+		}
+
+		err = s.whileLinked(ctx, userID, dataKey.DataType, func(ctx context.Context, tx *repository.Postgres) error {
+			return tx.CreateTraits(ctx, traits)
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to create traits")
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) GetUserTraits(ctx context.Context, userID uuid.UUID, dataType models.DataType, history bool) ([]models.Trait, error) {
+	if history {
+		return s.repo.GetTraitHistoryByUser(ctx, userID, dataType)
+	}
+
+	traits, err := s.repo.GetLatestTraitsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataType == "" {
+		return traits, nil
+	}
+
+	var filtered []models.Trait
+	for _, trait := range traits {
+		if trait.DataType == dataType {
+			filtered = append(filtered, trait)
+		}
+	}
+	return filtered, nil
+}
+
+// StartSyncRun records a running sync of a source, it returns ErrSourceNotLinked once the source is unlinked.
+func (s *Service) StartSyncRun(ctx context.Context, userID uuid.UUID, dataType models.DataType, kind models.SyncRunKind) (*models.SyncRun, error) {
+	run := &models.SyncRun{
+		UserID:    userID,
+		DataType:  dataType,
+		Kind:      kind,
+		Status:    models.SyncStatusRunning,
+		StartedAt: time.Now(),
+	}
+
+	err := s.whileLinked(ctx, userID, dataType, func(ctx context.Context, tx *repository.Postgres) error {
+		return tx.CreateSyncRun(ctx, run)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// FinishSyncRun marks the run succeeded, or failed with runErr, and persists its counters.
+func (s *Service) FinishSyncRun(ctx context.Context, run *models.SyncRun, runErr error) {
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	run.Status = models.SyncStatusSucceeded
+	if runErr != nil {
+		run.Status = models.SyncStatusFailed
+		run.LastError = runErr.Error()
+	}
+
+	err := s.repo.UpdateSyncRun(ctx, run)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// the source was unlinked while the run was going and its runs purged, nothing is left to report on
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Unable to update sync run %s", run.ID)
+	}
+
+	// a finished import hands over to the stats pass, which is the last step of a sync
+	event := progress.Event{
+		UserID:   run.UserID,
+		DataType: run.DataType,
+		Phase:    progress.PhaseAggregating,
+	}
+	switch {
+	case runErr != nil:
+		event.Phase = progress.PhaseFailed
+		event.Error = runErr.Error()
+	case run.Kind == models.SyncRunKindStats:
+		event.Phase = progress.PhaseDone
+	}
+	s.publishProgress(ctx, event)
+}
+
+func (s *Service) GetUserSyncStatus(ctx context.Context, userID uuid.UUID, dataType models.DataType) (map[models.DataType]map[models.SyncRunKind]models.SyncRun, error) {
+	runs, err := s.repo.GetLatestSyncRunsByUser(ctx, userID, dataType)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make(map[models.DataType]map[models.SyncRunKind]models.SyncRun)
+	for _, run := range runs {
+		if _, ok := status[run.DataType]; !ok {
+			status[run.DataType] = make(map[models.SyncRunKind]models.SyncRun)
+		}
+		status[run.DataType][run.Kind] = run
+	}
+	return status, nil
+}
+
+func (s *Service) publishProgress(ctx context.Context, event progress.Event) {
+	if s.broker == nil {
+		return
+	}
+
+	event.Time = time.Now()
+	if err := s.broker.Publish(ctx, event); err != nil {
+		log.Error().Err(err).Msgf("Unable to publish %s progress for user %s", event.Phase, event.UserID)
+	}
+}
+
+// SubscribeSyncProgress streams the sync progress of a user until ctx is done.
+func (s *Service) SubscribeSyncProgress(ctx context.Context, userID uuid.UUID) (<-chan progress.Event, error) {
+	if s.broker == nil {
+		return nil, fmt.Errorf("sync progress is not available")
+	}
+	return s.broker.Subscribe(ctx, userID)
+}
+
+var ErrSourceNotLinked = errors.New("source is not linked")
+
+// whileLinked runs write in a transaction holding a lock on the source's data key. UnlinkSource deletes the key
+// before anything else, so a write either commits first and is purged with the source, or fails with
+// ErrSourceNotLinked once the source is gone.
+func (s *Service) whileLinked(ctx context.Context, userID uuid.UUID, dataType models.DataType, write func(context.Context, *repository.Postgres) error) error {
+	return s.repo.Transaction(ctx, func(ctx context.Context, tx *repository.Postgres) error {
+		if err := tx.LockDataKey(ctx, userID, dataType); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrSourceNotLinked
+			}
+			return err
+		}
+		return write(ctx, tx)
+	})
+}
+
+// UnlinkSource disconnects a source from a user, cancelling its queued syncs and permanently deleting
+// everything imported from it.
+func (s *Service) UnlinkSource(ctx context.Context, userID uuid.UUID, dataType models.DataType) (*models.SourcePurge, error) {
+	if _, err := s.repo.GetDataKey(ctx, userID, dataType); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSourceNotLinked
+		}
+		return nil, err
+	}
+
+	// cancel first so a queued import cannot write the source back once it is purged, imports already running
+	// are stopped by the purge deleting the data key they lock for every write
+	cancelled, err := s.wt.CancelUserTasks(userID, dataType)
+	if err != nil {
+		return nil, err
+	}
+
+	var purge *models.SourcePurge
+	err = s.repo.Transaction(ctx, func(ctx context.Context, tx *repository.Postgres) error {
+		purge, err = tx.DeleteSourceData(ctx, userID, dataType)
+		if err != nil || !isViewingDataType(dataType) {
+			return err
+		}
+
+		// streaks and binges are derived from the purged viewings, binges even list their titles
+		purge.ViewingStreaks, purge.BingeSessions, err = tx.DeleteViewingHabits(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return refreshViewingHabits(ctx, tx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	purge.CancelledTasks = cancelled
+	log.Info().Interface("purge", purge).Msgf("Unlinked %s for user %s", dataType, userID)
+	return purge, nil
+}
+
+// viewingDataTypes are the sources whose activity counts towards viewing streaks.
+var viewingDataTypes = []models.DataType{models.DataTypeNetflix, models.DataTypeYoutube}
+
+const (
+	// minBingeEpisodes is how many episodes of one series on a single day make a binge
+	minBingeEpisodes = 3
+	maxBingeSessions = 50
+)
+
+// RefreshViewingHabits recomputes a user's viewing streaks and binge sessions from their activities.
+func (s *Service) RefreshViewingHabits(ctx context.Context, userID uuid.UUID) error {
+	return s.repo.Transaction(ctx, func(ctx context.Context, tx *repository.Postgres) error {
+		return refreshViewingHabits(ctx, tx, userID)
+	})
+}
+
+func refreshViewingHabits(ctx context.Context, tx *repository.Postgres, userID uuid.UUID) error {
+	viewings, err := tx.GetViewingsByUser(ctx, userID, viewingDataTypes)
+	if err != nil {
+		return err
+	}
+
+	streak := computeViewingStreak(viewings, time.Now())
+	streak.UserID = userID
+
+	binges := detectBingeSessions(viewings)
+	for _, binge := range binges {
+		binge.UserID = userID
+	}
+
+	return tx.ReplaceViewingHabits(ctx, userID, streak, binges)
+}
+
+func isViewingDataType(dataType models.DataType) bool {
+	for _, viewing := range viewingDataTypes {
+		if viewing == dataType {
+			return true
+		}
+	}
+	return false
+}
+
+func viewingDay(date time.Time) time.Time {
+	return date.UTC().Truncate(24 * time.Hour)
+}
+
+// computeViewingStreak finds the longest and current runs of consecutive viewing days in viewings, which must
+// be sorted oldest first.
+func computeViewingStreak(viewings []models.Activity, now time.Time) *models.ViewingStreak {
+	streak := &models.ViewingStreak{}
+
+	var runStart, lastDay time.Time
+	run := 0
+	for _, viewing := range viewings {
+		day := viewingDay(viewing.Date)
+		switch {
+		case run > 0 && day.Equal(lastDay):
+			continue
+		case run > 0 && day.Equal(lastDay.AddDate(0, 0, 1)):
+			run++
+		default:
+			run, runStart = 1, day
+		}
+		lastDay = day
+
+		if run > streak.Longest {
+			start, end := runStart, day
+			streak.Longest, streak.LongestStart, streak.LongestEnd = run, &start, &end
+		}
+	}
+
+	if run == 0 {
+		return streak
+	}
+
+	last := lastDay
+	streak.LastViewingOn = &last
+	today := viewingDay(now)
+	if lastDay.Equal(today) || lastDay.Equal(today.AddDate(0, 0, -1)) {
+		start := runStart
+		streak.Current, streak.CurrentStart = run, &start
+	}
+	return streak
+}
+
+// detectBingeSessions groups netflix episodes by day and series, viewings must be sorted oldest first.
+// Watching an episode again the same day does not count it twice.
+func detectBingeSessions(viewings []models.Activity) []*models.BingeSession {
+	type bingeKey struct {
+		day    time.Time
+		series string
+	}
+
+	sessions := make(map[bingeKey]*models.BingeSession)
+	watched := make(map[bingeKey]map[string]bool)
+	var order []bingeKey
+	for _, viewing := range viewings {
+		if viewing.DataType != models.DataTypeNetflix {
+			continue
+		}
+		title := mapper.ParseNetflixTitle(viewing.Title)
+		if title.Kind != mapper.TitleKindEpisode {
+			continue
+		}
+
+		key := bingeKey{day: viewingDay(viewing.Date), series: title.Series}
+		session, ok := sessions[key]
+		if !ok {
+			session = &models.BingeSession{Date: key.day, Series: title.Series}
+			sessions[key] = session
+			watched[key] = make(map[string]bool)
+			order = append(order, key)
+		}
+		if watched[key][viewing.Title] {
+			continue
+		}
+		watched[key][viewing.Title] = true
+		session.Episodes++
+		session.Titles = append(session.Titles, viewing.Title)
+	}
+
+	binges := make([]*models.BingeSession, 0)
+	for _, key := range order {
+		if session := sessions[key]; session.Episodes >= minBingeEpisodes {
+			binges = append(binges, session)
+		}
+	}
+	return binges
+}
+
+// titleBatchSize is how many netflix activities are parsed per pass of RefreshNetflixTitles
+const titleBatchSize = 1000
+
+// RefreshNetflixTitles parses the titles of netflix activities that have no episode yet, and returns how many
+// were parsed. Titles the parser cannot place are stored with their raw title so they are not parsed again.
+func (s *Service) RefreshNetflixTitles(ctx context.Context, userID uuid.UUID) (int, error) {
+	parsed := 0
+	for {
+		activities, err := s.repo.GetNetflixActivitiesWithoutEpisode(ctx, userID, titleBatchSize)
+		if err != nil {
+			return parsed, err
+		}
+		if len(activities) == 0 {
+			return parsed, nil
+		}
+
+		titles := make([]mapper.NetflixTitle, len(activities))
+		var seriesTitles []string
+		seen := make(map[string]bool)
+		for i, activity := range activities {
+			titles[i] = mapper.ParseNetflixTitle(activity.Title)
+			if series := titles[i].Series; series != "" && !seen[series] {
+				seen[series] = true
+				seriesTitles = append(seriesTitles, series)
+			}
+		}
+
+		err = s.repo.Transaction(ctx, func(ctx context.Context, tx *repository.Postgres) error {
+			seriesIDs, err := tx.FindOrCreateSeries(ctx, seriesTitles)
+			if err != nil {
+				return err
+			}
+
+			episodes := make([]*models.Episode, 0, len(activities))
+			for i, activity := range activities {
+				title := titles[i]
+				episode := &models.Episode{
+					UserID:       userID,
+					ActivityID:   activity.ID,
+					Kind:         string(title.Kind),
+					Season:       title.Season,
+					SeasonNumber: title.SeasonNumber,
+					Name:         title.Episode,
+					RawTitle:     activity.Title,
+					WatchedAt:    activity.Date,
+				}
+				if id, ok := seriesIDs[title.Series]; ok {
+					episode.SeriesID = &id
+				}
+				episodes = append(episodes, episode)
+			}
+			return tx.CreateEpisodes(ctx, episodes)
+		})
+		if err != nil {
+			return parsed, err
+		}
+
+		parsed += len(activities)
+		if len(activities) < titleBatchSize {
+			return parsed, nil
+		}
+	}
+}
+
+func (s *Service) GetUserTopSeries(ctx context.Context, userID uuid.UUID, limit int) ([]models.SeriesStat, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	return s.repo.GetTopSeriesByUser(ctx, userID, limit)
+}
+
+func (s *Service) GetUserCompletedSeasons(ctx context.Context, userID uuid.UUID) ([]models.CompletedSeason, error) {
+	return s.repo.GetCompletedSeasonsByUser(ctx, userID)
+}
+
+func (s *Service) GetUserTitleSplit(ctx context.Context, userID uuid.UUID) (*models.TitleSplit, error) {
+	return s.repo.GetTitleSplitByUser(ctx, userID)
+}
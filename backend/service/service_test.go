@@ -0,0 +1,393 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gandalf-data-aggregator/mapper"
+	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/webapi/eyeofsauron"
+	"gandalf-data-aggregator/webapi/fakesauron"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/google/uuid"
+)
+
+// memoryActivityStore keeps imported activities by provider id, the way CreateActivities skips duplicates.
+type memoryActivityStore struct {
+	mutex      sync.Mutex
+	activities map[string]bool
+	// afterStore is called once activities were stored, it lets a test change what gandalf serves mid import
+	afterStore func()
+}
+
+func newMemoryActivityStore() *memoryActivityStore {
+	return &memoryActivityStore{activities: make(map[string]bool)}
+}
+
+func (m *memoryActivityStore) storeActivities(ctx context.Context, dataKey *models.DataKey, activities []*models.Activity) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var inserted int64
+	for _, activity := range activities {
+		if !m.activities[activity.ProviderActivityID] {
+			m.activities[activity.ProviderActivityID] = true
+			inserted++
+		}
+	}
+	if m.afterStore != nil {
+		m.afterStore()
+	}
+	return inserted, nil
+}
+
+func (m *memoryActivityStore) saveSyncState(ctx context.Context, dataKey *models.DataKey) error {
+	return nil
+}
+
+func (m *memoryActivityStore) count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.activities)
+}
+
+type importTest struct {
+	sauron  *fakesauron.Server
+	service *Service
+	store   *memoryActivityStore
+	source  *activitySource
+}
+
+func newImportTest(t *testing.T, concurrency int) *importTest {
+	t.Helper()
+
+	privateKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+
+	sauron, err := fakesauron.New(hex.EncodeToString(privateKey.PubKey().SerializeCompressed()))
+	if err != nil {
+		t.Fatalf("unable to start fake sauron: %v", err)
+	}
+	httpServer := httptest.NewServer(sauron)
+	t.Cleanup(httpServer.Close)
+
+	client, err := eyeofsauron.New(httpServer.URL, hex.EncodeToString(privateKey.Serialize()), eyeofsauron.WithHTTPClient(httpServer.Client()))
+	if err != nil {
+		t.Fatalf("unable to build sauron client: %v", err)
+	}
+
+	store := newMemoryActivityStore()
+	service := &Service{
+		gandalfClient: eyeofsauron.NewRateLimitedClient(client, eyeofsauron.RateLimit{RequestsPerSecond: 100, Burst: 10}),
+		activityStore: store,
+	}
+	service.cfg.Sync.FetchConcurrency = concurrency
+
+	return &importTest{
+		sauron:  sauron,
+		service: service,
+		store:   store,
+		source: &activitySource{
+			dataKey: &models.DataKey{UserID: uuid.New(), DataType: models.DataTypeNetflix},
+			key:     "data-key",
+			mapper:  mapper.NetflixMapper{},
+		},
+	}
+}
+
+// serve lists the netflix activities with the given numbers, in order.
+func (i *importTest) serve(numbers ...int) {
+	activities := make([]json.RawMessage, len(numbers))
+	for n, number := range numbers {
+		activities[n] = json.RawMessage(fmt.Sprintf(`{"id":%q,"metadata":{"__typename":"NetflixActivityMetadata","title":"Title %d","subject":[],"date":"01/02/2023","lastPlayedAt":"01/02/2023"}}`, activityID(number), number))
+	}
+	i.sauron.SetFixture(string(eyeofsauron.SourceNetflix), fakesauron.Fixture{Activities: activities})
+}
+
+func (i *importTest) sync(t *testing.T, limit int64) *FetchResult {
+	t.Helper()
+
+	result, err := i.service.importActivities(context.Background(), i.source, limit)
+	if err != nil {
+		t.Fatalf("import returned error: %v", err)
+	}
+	return result
+}
+
+func activityID(number int) string {
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", number)
+}
+
+// numbers returns from, from+step, ... up to but excluding to.
+func numbers(from, to, step int) []int {
+	var numbers []int
+	for n := from; n != to; n += step {
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+func TestIncrementalSyncOfAppendedActivities(t *testing.T) {
+	test := newImportTest(t, 2)
+	test.serve(numbers(0, 7, 1)...)
+
+	result := test.sync(t, 3)
+	if result.Inserted != 7 || test.store.count() != 7 {
+		t.Fatalf("initial import inserted %d, stored %d, want 7", result.Inserted, test.store.count())
+	}
+	dataKey := test.source.dataKey
+	if dataKey.LastSyncedAt == nil || dataKey.LastActivityID != activityID(0) || dataKey.Total != 7 {
+		t.Fatalf("initial import left sync state %v, %q, %d", dataKey.LastSyncedAt, dataKey.LastActivityID, dataKey.Total)
+	}
+
+	test.serve(numbers(0, 11, 1)...)
+	result = test.sync(t, 3)
+	if result.Inserted != 4 || test.store.count() != 11 {
+		t.Errorf("resync inserted %d, stored %d, want 4 of 11", result.Inserted, test.store.count())
+	}
+	// the first page tells the activities were appended, the resync resumes at the last known page
+	if result.Pages != 3 {
+		t.Errorf("resync read %d pages, want 3", result.Pages)
+	}
+	if dataKey.LastActivityID != activityID(0) || dataKey.Total != 11 {
+		t.Errorf("resync left sync state %q, %d", dataKey.LastActivityID, dataKey.Total)
+	}
+}
+
+func TestIncrementalSyncOfPrependedActivities(t *testing.T) {
+	test := newImportTest(t, 2)
+	test.serve(numbers(6, -1, -1)...)
+
+	if result := test.sync(t, 3); result.Inserted != 7 {
+		t.Fatalf("initial import inserted %d, want 7", result.Inserted)
+	}
+
+	test.serve(numbers(10, -1, -1)...)
+	result := test.sync(t, 3)
+	if result.Inserted != 4 || test.store.count() != 11 {
+		t.Errorf("resync inserted %d, stored %d, want 4 of 11", result.Inserted, test.store.count())
+	}
+	// page two holds the previously first activity, nothing past it is read
+	if result.Pages != 2 || result.Fetched != 4 {
+		t.Errorf("resync read %d pages and fetched %d, want 2 pages and 4 activities", result.Pages, result.Fetched)
+	}
+	if test.source.dataKey.LastActivityID != activityID(10) {
+		t.Errorf("resync recorded first activity %q, want %q", test.source.dataKey.LastActivityID, activityID(10))
+	}
+}
+
+func TestIncrementalSyncWithoutNewActivities(t *testing.T) {
+	test := newImportTest(t, 2)
+	test.serve(numbers(0, 7, 1)...)
+	test.sync(t, 3)
+
+	result := test.sync(t, 3)
+	if result.Inserted != 0 || result.Pages != 1 {
+		t.Errorf("resync inserted %d from %d pages, want nothing from a single page", result.Inserted, result.Pages)
+	}
+}
+
+func TestInitialImportFetchesPlannedPages(t *testing.T) {
+	test := newImportTest(t, 4)
+	test.serve(numbers(0, 20, 1)...)
+
+	result := test.sync(t, 3)
+	if result.Inserted != 20 || test.store.count() != 20 {
+		t.Errorf("import inserted %d, stored %d, want 20", result.Inserted, test.store.count())
+	}
+	if result.Pages != 7 || test.source.dataKey.LastPage != 7 {
+		t.Errorf("import read %d pages and recorded page %d, want 7", result.Pages, test.source.dataKey.LastPage)
+	}
+}
+
+func TestInitialImportResumesAfterPersistedPages(t *testing.T) {
+	test := newImportTest(t, 4)
+	test.serve(numbers(0, 20, 1)...)
+	test.source.dataKey.LastPage = 2
+
+	result := test.sync(t, 3)
+	if result.Inserted != 14 || result.Pages != 5 {
+		t.Errorf("resumed import inserted %d from %d pages, want 14 from 5", result.Inserted, result.Pages)
+	}
+	for _, number := range numbers(0, 6, 1) {
+		if test.store.activities[activityID(number)] {
+			t.Errorf("resumed import read activity %d of an already persisted page", number)
+		}
+	}
+	if test.source.dataKey.LastPage != 7 || test.source.dataKey.LastSyncedAt == nil {
+		t.Errorf("resumed import recorded page %d, synced at %v", test.source.dataKey.LastPage, test.source.dataKey.LastSyncedAt)
+	}
+}
+
+func TestInitialImportReadsActivitiesAddedDuringIt(t *testing.T) {
+	test := newImportTest(t, 2)
+	test.serve(numbers(0, 7, 1)...)
+
+	var once sync.Once
+	test.store.afterStore = func() {
+		once.Do(func() { test.serve(numbers(0, 13, 1)...) })
+	}
+
+	result := test.sync(t, 3)
+	if result.Inserted != 13 || test.store.count() != 13 {
+		t.Errorf("import inserted %d, stored %d, want the 13 listed when it ended", result.Inserted, test.store.count())
+	}
+	if test.source.dataKey.LastPage != 5 || test.source.dataKey.Total != 13 {
+		t.Errorf("import recorded page %d of %d activities, want page 5 of 13", test.source.dataKey.LastPage, test.source.dataKey.Total)
+	}
+}
+
+func viewingsAt(dates ...string) []models.Activity {
+	viewings := make([]models.Activity, len(dates))
+	for i, date := range dates {
+		parsed, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			panic(err)
+		}
+		viewings[i] = models.Activity{DataType: models.DataTypeNetflix, Date: parsed}
+	}
+	return viewings
+}
+
+func day(date string) time.Time {
+	parsed, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func TestComputeViewingStreak(t *testing.T) {
+	tests := []struct {
+		name         string
+		viewings     []models.Activity
+		now          string
+		longest      int
+		longestStart string
+		current      int
+		currentStart string
+	}{
+		{
+			name: "no viewings",
+			now:  "2024-03-10T12:00:00Z",
+		},
+		{
+			name:         "gaps end a run",
+			viewings:     viewingsAt("2024-03-01T10:00:00Z", "2024-03-02T10:00:00Z", "2024-03-03T10:00:00Z", "2024-03-05T10:00:00Z", "2024-03-06T10:00:00Z"),
+			now:          "2024-03-20T12:00:00Z",
+			longest:      3,
+			longestStart: "2024-03-01",
+		},
+		{
+			name:         "several viewings a day count once",
+			viewings:     viewingsAt("2024-03-01T08:00:00Z", "2024-03-01T20:00:00Z", "2024-03-02T08:00:00Z", "2024-03-02T09:00:00Z", "2024-03-03T10:00:00Z"),
+			now:          "2024-03-20T12:00:00Z",
+			longest:      3,
+			longestStart: "2024-03-01",
+		},
+		{
+			name:         "a run ending today is current",
+			viewings:     viewingsAt("2024-03-08T10:00:00Z", "2024-03-09T10:00:00Z", "2024-03-10T10:00:00Z"),
+			now:          "2024-03-10T23:00:00Z",
+			longest:      3,
+			longestStart: "2024-03-08",
+			current:      3,
+			currentStart: "2024-03-08",
+		},
+		{
+			name:         "a run ending yesterday is still current",
+			viewings:     viewingsAt("2024-03-01T10:00:00Z", "2024-03-02T10:00:00Z", "2024-03-03T10:00:00Z", "2024-03-08T10:00:00Z", "2024-03-09T10:00:00Z"),
+			now:          "2024-03-10T00:30:00Z",
+			longest:      3,
+			longestStart: "2024-03-01",
+			current:      2,
+			currentStart: "2024-03-08",
+		},
+		{
+			name:         "a run ending two days ago is not current",
+			viewings:     viewingsAt("2024-03-08T10:00:00Z", "2024-03-09T10:00:00Z"),
+			now:          "2024-03-11T00:30:00Z",
+			longest:      2,
+			longestStart: "2024-03-08",
+		},
+		{
+			name:         "days are UTC days",
+			viewings:     viewingsAt("2024-03-01T23:30:00-05:00", "2024-03-02T12:00:00Z", "2024-03-02T23:30:00Z", "2024-03-03T00:30:00Z"),
+			now:          "2024-03-20T12:00:00Z",
+			longest:      2,
+			longestStart: "2024-03-02",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			streak := computeViewingStreak(test.viewings, now)
+
+			if streak.Longest != test.longest || streak.Current != test.current {
+				t.Fatalf("got longest %d and current %d, want %d and %d", streak.Longest, streak.Current, test.longest, test.current)
+			}
+			if test.longest > 0 && !streak.LongestStart.Equal(day(test.longestStart)) {
+				t.Errorf("longest run starts %s, want %s", streak.LongestStart, test.longestStart)
+			}
+			if test.current > 0 && !streak.CurrentStart.Equal(day(test.currentStart)) {
+				t.Errorf("current run starts %s, want %s", streak.CurrentStart, test.currentStart)
+			}
+			if test.current == 0 && streak.CurrentStart != nil {
+				t.Errorf("current run starts %s, want none", streak.CurrentStart)
+			}
+		})
+	}
+}
+
+func TestDetectBingeSessions(t *testing.T) {
+	episode := func(date, title string) models.Activity {
+		viewing := viewingsAt(date)[0]
+		viewing.Title = title
+		return viewing
+	}
+
+	viewings := []models.Activity{
+		// three episodes of one series on a day make a binge
+		episode("2024-03-01T18:00:00Z", "Dark: Season 1: Secrets"),
+		episode("2024-03-01T19:00:00Z", "Dark: Season 1: Lies"),
+		episode("2024-03-01T20:00:00Z", "Dark: Season 1: Past and Present"),
+		// the same episode three times does not
+		episode("2024-03-02T18:00:00Z", "Dark: Season 2: Beginnings and Endings"),
+		episode("2024-03-02T19:00:00Z", "Dark: Season 2: Beginnings and Endings"),
+		episode("2024-03-02T20:00:00Z", "Dark: Season 2: Beginnings and Endings"),
+		// nor three episodes split over two UTC days
+		episode("2024-03-03T22:00:00Z", "Ozark: Season 1: Sugarwood"),
+		episode("2024-03-03T23:00:00Z", "Ozark: Season 1: Blue Cat"),
+		episode("2024-03-04T00:30:00Z", "Ozark: Season 1: My Dripping Sleep"),
+		// movies and other sources are no episodes
+		episode("2024-03-05T18:00:00Z", "The Irishman"),
+		episode("2024-03-05T19:00:00Z", "Glass Onion"),
+		episode("2024-03-05T20:00:00Z", "Roma"),
+	}
+	youtube := episode("2024-03-01T21:00:00Z", "Dark: Season 1: Ghosts")
+	youtube.DataType = models.DataTypeYoutube
+	viewings = append(viewings, youtube)
+
+	binges := detectBingeSessions(viewings)
+	if len(binges) != 1 {
+		t.Fatalf("got %d binges, want 1: %+v", len(binges), binges)
+	}
+
+	binge := binges[0]
+	if binge.Series != "Dark" || !binge.Date.Equal(day("2024-03-01")) || binge.Episodes != 3 || len(binge.Titles) != 3 {
+		t.Errorf("got a binge of %d episodes %v of %q on %s, want 3 episodes of Dark on 2024-03-01", binge.Episodes, binge.Titles, binge.Series, binge.Date)
+	}
+}
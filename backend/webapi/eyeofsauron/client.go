@@ -0,0 +1,50 @@
+package eyeofsauron
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	graphql2 "github.com/gandalf-network/gandalf-sdk-go/eyeofsauron/graphql"
+)
+
+// DefaultTimeout bounds a single request to sauron when no http client is injected.
+const DefaultTimeout = 30 * time.Second
+
+type Option func(*options)
+
+type options struct {
+	httpClient *http.Client
+}
+
+// WithHTTPClient overrides the http client used to reach sauron.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = httpClient
+	}
+}
+
+// New builds a client against the given sauron endpoint. Unlike the generated NewEyeOfSauron
+// it does not assume a local sauron, so it survives regenerating generated.go.
+func New(url string, privateKey string, opts ...Option) (*EyeOfSauron, error) {
+	if url == "" {
+		return nil, fmt.Errorf("sauron url is required")
+	}
+
+	o := &options{
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	privKey, err := HexToECDSAPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+
+	return &EyeOfSauron{
+		privateKey: privKey,
+		client:     graphql2.NewClient(url, graphql2.WithHTTPClient(o.httpClient)),
+	}, nil
+}
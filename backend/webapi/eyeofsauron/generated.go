@@ -19,6 +19,7 @@ import (
 	graphql2 "github.com/gandalf-network/gandalf-sdk-go/eyeofsauron/graphql"
 	"github.com/gandalf-network/gandalf-sdk-go/eyeofsauron/graphqlTypes"
 	"github.com/gandalf-network/genqlient/graphql"
+	"github.com/google/uuid"
 )
 
 type EyeOfSauron struct {
@@ -974,15 +975,15 @@ func (v *GetAppByPublicKeyApplication) GetAppRegistrar() string { return v.AppRe
 
 // GetTraitsTrait includes the requested fields of the GraphQL type Trait.
 type GetTraitsTrait struct {
-	Id        graphqlTypes.UUID `json:"id"`
-	Source    Source            `json:"source"`
-	Label     TraitLabel        `json:"label"`
-	Value     string            `json:"value"`
-	Timestamp time.Time         `json:"timestamp"`
+	Id        uuid.UUID  `json:"id"`
+	Source    Source     `json:"source"`
+	Label     TraitLabel `json:"label"`
+	Value     string     `json:"value"`
+	Timestamp time.Time  `json:"timestamp"`
 }
 
 // GetId returns GetTraitsTrait.Id, and is useful for accessing the field via an interface.
-func (v *GetTraitsTrait) GetId() graphqlTypes.UUID { return v.Id }
+func (v *GetTraitsTrait) GetId() uuid.UUID { return v.Id }
 
 // GetSource returns GetTraitsTrait.Source, and is useful for accessing the field via an interface.
 func (v *GetTraitsTrait) GetSource() Source { return v.Source }
@@ -1899,15 +1900,15 @@ func (v *LookupActivityMetadataYoutubeActivityMetadata) __premarshalJSON() (*__p
 
 // LookupTrait includes the requested fields of the GraphQL type Trait.
 type LookupTrait struct {
-	Id        graphqlTypes.UUID `json:"id"`
-	Source    Source            `json:"source"`
-	Label     TraitLabel        `json:"label"`
-	Value     string            `json:"value"`
-	Timestamp time.Time         `json:"timestamp"`
+	Id        uuid.UUID  `json:"id"`
+	Source    Source     `json:"source"`
+	Label     TraitLabel `json:"label"`
+	Value     string     `json:"value"`
+	Timestamp time.Time  `json:"timestamp"`
 }
 
 // GetId returns LookupTrait.Id, and is useful for accessing the field via an interface.
-func (v *LookupTrait) GetId() graphqlTypes.UUID { return v.Id }
+func (v *LookupTrait) GetId() uuid.UUID { return v.Id }
 
 // GetSource returns LookupTrait.Source, and is useful for accessing the field via an interface.
 func (v *LookupTrait) GetSource() Source { return v.Source }
@@ -2157,27 +2158,27 @@ func (v *__getTraitsInput) GetLabels() []TraitLabel { return v.Labels }
 
 // __lookupActivityInput is used internally by genqlient
 type __lookupActivityInput struct {
-	DataKey    string            `json:"dataKey"`
-	ActivityId graphqlTypes.UUID `json:"activityId"`
+	DataKey    string    `json:"dataKey"`
+	ActivityId uuid.UUID `json:"activityId"`
 }
 
 // GetDataKey returns __lookupActivityInput.DataKey, and is useful for accessing the field via an interface.
 func (v *__lookupActivityInput) GetDataKey() string { return v.DataKey }
 
 // GetActivityId returns __lookupActivityInput.ActivityId, and is useful for accessing the field via an interface.
-func (v *__lookupActivityInput) GetActivityId() graphqlTypes.UUID { return v.ActivityId }
+func (v *__lookupActivityInput) GetActivityId() uuid.UUID { return v.ActivityId }
 
 // __lookupTraitInput is used internally by genqlient
 type __lookupTraitInput struct {
-	DataKey string            `json:"dataKey"`
-	TraitId graphqlTypes.UUID `json:"traitId"`
+	DataKey string    `json:"dataKey"`
+	TraitId uuid.UUID `json:"traitId"`
 }
 
 // GetDataKey returns __lookupTraitInput.DataKey, and is useful for accessing the field via an interface.
 func (v *__lookupTraitInput) GetDataKey() string { return v.DataKey }
 
 // GetTraitId returns __lookupTraitInput.TraitId, and is useful for accessing the field via an interface.
-func (v *__lookupTraitInput) GetTraitId() graphqlTypes.UUID { return v.TraitId }
+func (v *__lookupTraitInput) GetTraitId() uuid.UUID { return v.TraitId }
 
 // getActivityResponse is returned by getActivity on success.
 type getActivityResponse struct {
@@ -2596,7 +2597,7 @@ fragment InstacartActivityMetadata on InstacartActivityMetadata {
 func (eye EyeOfSauron) LookupActivity(
 	ctx_ context.Context,
 	dataKey string,
-	activityId graphqlTypes.UUID,
+	activityId uuid.UUID,
 ) (*lookupActivityResponse, error) {
 	req := graphql2.NewRequest(lookupActivity_Operation)
 
@@ -2654,7 +2655,7 @@ query lookupTrait ($dataKey: String!, $traitId: UUID!) {
 func (eye EyeOfSauron) LookupTrait(
 	ctx_ context.Context,
 	dataKey string,
-	traitId graphqlTypes.UUID,
+	traitId uuid.UUID,
 ) (*lookupTraitResponse, error) {
 	req := graphql2.NewRequest(lookupTrait_Operation)
 
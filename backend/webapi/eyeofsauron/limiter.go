@@ -0,0 +1,237 @@
+package eyeofsauron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gandalf-network/gandalf-sdk-go/eyeofsauron/graphqlTypes"
+	"golang.org/x/time/rate"
+)
+
+var (
+	// ErrPermanent marks gandalf errors that will fail the same way however often they are retried.
+	ErrPermanent = errors.New("permanent gandalf error")
+	// ErrDataKeyRejected is a permanent error for a data key gandalf no longer accepts, usually
+	// because the user revoked access, and the user has to connect the source again.
+	ErrDataKeyRejected = fmt.Errorf("%w: data key rejected", ErrPermanent)
+)
+
+// VerifyTimeout bounds a data key verification, the user waits on the callback page while it runs.
+const VerifyTimeout = 5 * time.Second
+
+type RateLimit struct {
+	// RequestsPerSecond is the sustained rate shared by every caller of the client
+	RequestsPerSecond float64
+	Burst             int
+	// MaxRetries is how many times a transient failure is retried before giving up
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// RateLimitedClient paces calls to sauron with a token bucket and retries transient failures
+// with exponential backoff. It is safe to share across worker goroutines.
+type RateLimitedClient struct {
+	client *EyeOfSauron
+	cfg    RateLimit
+
+	// limiter is halved whenever sauron pushes back and slowly grows back to the configured rate
+	limiter *rate.Limiter
+	mutex   sync.Mutex
+}
+
+func NewRateLimitedClient(client *EyeOfSauron, cfg RateLimit) *RateLimitedClient {
+	if cfg.RequestsPerSecond <= 0 {
+		cfg.RequestsPerSecond = 1
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = 1
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = 500 * time.Millisecond
+	}
+	if cfg.MaxDelay < cfg.BaseDelay {
+		cfg.MaxDelay = 30 * time.Second
+	}
+
+	return &RateLimitedClient{
+		client:  client,
+		cfg:     cfg,
+		limiter: rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), cfg.Burst),
+	}
+}
+
+func (c *RateLimitedClient) GetActivity(ctx context.Context, dataKey string, source Source, limit graphqlTypes.Int64, page graphqlTypes.Int64) (*getActivityResponse, error) {
+	var resp *getActivityResponse
+	err := c.do(ctx, c.cfg.MaxRetries, func() error {
+		var err error
+		resp, err = c.client.GetActivity(ctx, dataKey, source, limit, page)
+		return err
+	})
+	return resp, err
+}
+
+func (c *RateLimitedClient) GetTraits(ctx context.Context, dataKey string, source Source, labels []TraitLabel) (*getTraitsResponse, error) {
+	var resp *getTraitsResponse
+	err := c.do(ctx, c.cfg.MaxRetries, func() error {
+		var err error
+		resp, err = c.client.GetTraits(ctx, dataKey, source, labels)
+		return err
+	})
+	return resp, err
+}
+
+// VerifyDataKey checks gandalf accepts a data key for source with the cheapest authenticated call there is,
+// reading a single activity. Rejected keys fail with ErrDataKeyRejected. It is not retried and gives up after
+// VerifyTimeout, a transient failure is better reported to the user than left hanging.
+func (c *RateLimitedClient) VerifyDataKey(ctx context.Context, dataKey string, source Source) error {
+	ctx, cancel := context.WithTimeout(ctx, VerifyTimeout)
+	defer cancel()
+
+	return c.do(ctx, 0, func() error {
+		_, err := c.client.GetActivity(ctx, dataKey, source, 1, 1)
+		return err
+	})
+}
+
+func (c *RateLimitedClient) do(ctx context.Context, maxRetries int, call func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		err = call()
+		if err == nil {
+			c.restore()
+			return nil
+		}
+
+		err = classify(err)
+		if errors.Is(err, ErrPermanent) || attempt >= maxRetries {
+			return err
+		}
+		if isThrottled(err) {
+			c.throttle()
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(c.backoff(attempt)):
+		}
+	}
+}
+
+// backoff returns a full jitter delay, a random duration up to base * 2^attempt capped at MaxDelay.
+func (c *RateLimitedClient) backoff(attempt int) time.Duration {
+	delay := c.cfg.BaseDelay << attempt
+	if delay <= 0 || delay > c.cfg.MaxDelay {
+		delay = c.cfg.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+func (c *RateLimitedClient) throttle() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	floor := rate.Limit(c.cfg.RequestsPerSecond / 16)
+	limit := c.limiter.Limit() / 2
+	if limit < floor {
+		limit = floor
+	}
+	c.limiter.SetLimit(limit)
+}
+
+func (c *RateLimitedClient) restore() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	target := rate.Limit(c.cfg.RequestsPerSecond)
+	if c.limiter.Limit() >= target {
+		return
+	}
+
+	limit := c.limiter.Limit() * 1.1
+	if limit > target {
+		limit = target
+	}
+	c.limiter.SetLimit(limit)
+}
+
+var statusCodePattern = regexp.MustCompile(`non-200 status code: (\d+)`)
+
+// dataKeyProblems are what sauron says about a data key the user revoked or that expired. Only errors naming the
+// data key count as a rejection, a bad signature or app key fails every request and is no fault of the key.
+var dataKeyProblems = []string{
+	"invalid",
+	"expired",
+	"revoked",
+	"not found",
+}
+
+// permanentMessages are errors sauron reports for requests that can never succeed.
+var permanentMessages = []string{
+	"datakey is required",
+	"signature",
+	"unauthorized",
+	"unauthenticated",
+	"forbidden",
+	"not found",
+	"invalid source",
+}
+
+// classify wraps errors that should not be retried with ErrPermanent, and those rejecting the data key with
+// ErrDataKeyRejected. The generated client flattens errors into strings, so the message is all there is to go on.
+func classify(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	message := strings.ToLower(err.Error())
+	if match := statusCodePattern.FindStringSubmatch(message); match != nil {
+		status, _ := strconv.Atoi(match[1])
+		if status == 429 || status >= 500 {
+			return err
+		}
+		// a bare 401 or 403 says nothing about which credential failed, it is as likely the app key
+		return fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+
+	if strings.Contains(message, "graphql: ") {
+		if rejectsDataKey(message) {
+			return fmt.Errorf("%w: %v", ErrDataKeyRejected, err)
+		}
+		for _, permanent := range permanentMessages {
+			if strings.Contains(message, permanent) {
+				return fmt.Errorf("%w: %v", ErrPermanent, err)
+			}
+		}
+	}
+	return err
+}
+
+func rejectsDataKey(message string) bool {
+	if !strings.Contains(message, "data key") && !strings.Contains(message, "datakey") {
+		return false
+	}
+	for _, problem := range dataKeyProblems {
+		if strings.Contains(message, problem) {
+			return true
+		}
+	}
+	return false
+}
+
+func isThrottled(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "status code: 429") || strings.Contains(message, "rate limit")
+}
@@ -0,0 +1,80 @@
+package eyeofsauron
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		message   string
+		permanent bool
+		rejected  bool
+	}{
+		{"graphql: invalid data key", true, true},
+		{"graphql: data key expired", true, true},
+		{"graphql: datakey has been revoked", true, true},
+		{"graphql: server returned a non-200 status code: 401", true, false},
+		{"graphql: server returned a non-200 status code: 403", true, false},
+		{"graphql: invalid signature", true, false},
+		{"graphql: unauthorized", true, false},
+		{"graphql: application not found", true, false},
+		{"graphql: dataKey is required", true, false},
+		{"graphql: server returned a non-200 status code: 429", false, false},
+		{"graphql: server returned a non-200 status code: 502", false, false},
+		{"connection reset by peer", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			err := classify(errors.New(tt.message))
+			if got := errors.Is(err, ErrPermanent); got != tt.permanent {
+				t.Errorf("permanent = %v, want %v", got, tt.permanent)
+			}
+			if got := errors.Is(err, ErrDataKeyRejected); got != tt.rejected {
+				t.Errorf("rejected = %v, want %v", got, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestVerifyDataKeyIsNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	privateKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	client, err := New(server.URL, hex.EncodeToString(privateKey.Serialize()), WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("unable to build client: %v", err)
+	}
+	limited := NewRateLimitedClient(client, RateLimit{RequestsPerSecond: 100, Burst: 10, MaxRetries: 4, BaseDelay: time.Millisecond})
+
+	if err := limited.VerifyDataKey(context.Background(), "data-key", SourceNetflix); err == nil {
+		t.Fatal("VerifyDataKey returned no error for an unavailable sauron")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("VerifyDataKey called sauron %d times, want 1", calls.Load())
+	}
+
+	if _, err := limited.GetActivity(context.Background(), "data-key", SourceNetflix, 1, 1); err == nil {
+		t.Fatal("GetActivity returned no error for an unavailable sauron")
+	}
+	if calls.Load() != 6 {
+		t.Errorf("GetActivity called sauron %d times, want 5", calls.Load()-1)
+	}
+}
@@ -0,0 +1,334 @@
+// Package fakesauron serves the subset of the Gandalf Sauron GraphQL API this app calls,
+// backed by fixture data, so the worker can run without the live service.
+package fakesauron
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+//go:embed fixtures/*.json
+var fixtureFS embed.FS
+
+const SignatureHeader = "X-Gandalf-Signature"
+
+// Fixture holds the raw, wire formatted activities and traits served for a single source.
+type Fixture struct {
+	Activities []json.RawMessage `json:"activities"`
+	Traits     []json.RawMessage `json:"traits"`
+}
+
+// Application is returned from getAppByPublicKey.
+type Application struct {
+	AppName      string `json:"appName"`
+	PublicKey    string `json:"publicKey"`
+	IconURL      string `json:"iconURL"`
+	GandalfID    int64  `json:"gandalfID"`
+	AppRegistrar string `json:"appRegistrar"`
+}
+
+type Server struct {
+	publicKey *ecdsa.PublicKey
+	app       Application
+
+	mutex    sync.RWMutex
+	fixtures map[string]Fixture
+	// dataKeys restricts accepted data keys to those listed, any key is accepted while it is empty
+	dataKeys map[string]bool
+}
+
+// New returns a fake sauron that verifies request signatures against the hex encoded app public key.
+func New(publicKeyHex string) (*Server, error) {
+	pubKeyBytes, err := hex.DecodeString(strings.TrimPrefix(publicKeyHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode hex string: %v", err)
+	}
+
+	pubKey, err := btcec.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+
+	fixtures, err := loadFixtures()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		publicKey: pubKey.ToECDSA(),
+		app: Application{
+			AppName:      "Fake Sauron App",
+			PublicKey:    publicKeyHex,
+			IconURL:      "https://example.com/icon.png",
+			GandalfID:    1,
+			AppRegistrar: "0x0000000000000000000000000000000000000000",
+		},
+		fixtures: fixtures,
+		dataKeys: make(map[string]bool),
+	}, nil
+}
+
+func loadFixtures() (map[string]Fixture, error) {
+	entries, err := fixtureFS.ReadDir("fixtures")
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures := make(map[string]Fixture)
+	for _, entry := range entries {
+		raw, err := fixtureFS.ReadFile(path.Join("fixtures", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var fixture Fixture
+		if err := json.Unmarshal(raw, &fixture); err != nil {
+			return nil, fmt.Errorf("invalid fixture %s: %w", entry.Name(), err)
+		}
+
+		source := strings.ToUpper(strings.TrimSuffix(entry.Name(), path.Ext(entry.Name())))
+		fixtures[source] = fixture
+	}
+	return fixtures, nil
+}
+
+// SetFixture replaces the data served for a source, e.g. "NETFLIX".
+func (s *Server) SetFixture(source string, fixture Fixture) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.fixtures[source] = fixture
+}
+
+// AllowDataKeys limits the data keys the server accepts, others are rejected as invalid.
+func (s *Server) AllowDataKeys(keys ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, key := range keys {
+		s.dataKeys[key] = true
+	}
+}
+
+type graphRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphError struct {
+	Message string `json:"message"`
+}
+
+var operationPattern = regexp.MustCompile(`(?:query|mutation)\s+(\w+)`)
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "only POST is supported")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "unable to read body")
+		return
+	}
+
+	if err := s.verifySignature(body, r.Header.Get(SignatureHeader)); err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var req graphRequest
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid graphql request")
+		return
+	}
+
+	match := operationPattern.FindStringSubmatch(req.Query)
+	if match == nil {
+		writeError(w, http.StatusBadRequest, "missing operation name")
+		return
+	}
+
+	var data interface{}
+	switch match[1] {
+	case "getActivity":
+		data, err = s.getActivity(req.Variables)
+	case "lookupActivity":
+		data, err = s.lookupActivity(req.Variables)
+	case "getTraits":
+		data, err = s.getTraits(req.Variables)
+	case "getAppByPublicKey":
+		data, err = s.getAppByPublicKey(req.Variables)
+	default:
+		err = fmt.Errorf("unsupported operation %s", match[1])
+	}
+
+	if err != nil {
+		writeError(w, http.StatusOK, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// verifySignature checks the base64 ASN.1 ECDSA signature gandalf clients send over the sha256 of the raw body.
+func (s *Server) verifySignature(body []byte, signatureB64 string) error {
+	if signatureB64 == "" {
+		return fmt.Errorf("missing %s header", SignatureHeader)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(signatureB64)
+	if err != nil {
+		return fmt.Errorf("malformed signature")
+	}
+
+	hash := sha256.Sum256(body)
+	if !ecdsa.VerifyASN1(s.publicKey, hash[:], signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func (s *Server) checkDataKey(variables map[string]interface{}) error {
+	dataKey, _ := variables["dataKey"].(string)
+	if dataKey == "" {
+		return fmt.Errorf("dataKey is required")
+	}
+	if len(s.dataKeys) > 0 && !s.dataKeys[dataKey] {
+		return fmt.Errorf("invalid data key")
+	}
+	return nil
+}
+
+func (s *Server) getActivity(variables map[string]interface{}) (interface{}, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := s.checkDataKey(variables); err != nil {
+		return nil, err
+	}
+
+	source, _ := variables["source"].(string)
+	limit := intVariable(variables, "limit")
+	page := intVariable(variables, "page")
+	if limit <= 0 || page <= 0 {
+		return nil, fmt.Errorf("limit and page must be positive")
+	}
+
+	activities := s.fixtures[source].Activities
+	start := (page - 1) * limit
+	if start > len(activities) {
+		start = len(activities)
+	}
+	end := start + limit
+	if end > len(activities) {
+		end = len(activities)
+	}
+
+	return map[string]interface{}{
+		"getActivity": map[string]interface{}{
+			"data":  activities[start:end],
+			"limit": limit,
+			"total": len(activities),
+			"page":  page,
+		},
+	}, nil
+}
+
+func (s *Server) lookupActivity(variables map[string]interface{}) (interface{}, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := s.checkDataKey(variables); err != nil {
+		return nil, err
+	}
+
+	activityID, _ := variables["activityId"].(string)
+	for _, fixture := range s.fixtures {
+		for _, activity := range fixture.Activities {
+			var head struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(activity, &head); err == nil && head.ID == activityID {
+				return map[string]interface{}{"lookupActivity": activity}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("activity %s not found", activityID)
+}
+
+func (s *Server) getTraits(variables map[string]interface{}) (interface{}, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := s.checkDataKey(variables); err != nil {
+		return nil, err
+	}
+
+	source, _ := variables["source"].(string)
+	labels := make(map[string]bool)
+	if requested, ok := variables["labels"].([]interface{}); ok {
+		for _, label := range requested {
+			if value, ok := label.(string); ok {
+				labels[value] = true
+			}
+		}
+	}
+
+	traits := make([]json.RawMessage, 0)
+	for _, trait := range s.fixtures[source].Traits {
+		var head struct {
+			Label string `json:"label"`
+		}
+		if err := json.Unmarshal(trait, &head); err == nil && labels[head.Label] {
+			traits = append(traits, trait)
+		}
+	}
+
+	return map[string]interface{}{"getTraits": traits}, nil
+}
+
+func (s *Server) getAppByPublicKey(variables map[string]interface{}) (interface{}, error) {
+	publicKey, _ := variables["publicKey"].(string)
+	if !strings.EqualFold(strings.TrimPrefix(publicKey, "0x"), strings.TrimPrefix(s.app.PublicKey, "0x")) {
+		return nil, fmt.Errorf("application not found")
+	}
+	return map[string]interface{}{"getAppByPublicKey": s.app}, nil
+}
+
+func intVariable(variables map[string]interface{}, name string) int {
+	switch value := variables[name].(type) {
+	case float64:
+		return int(value)
+	case string:
+		var parsed int
+		_, _ = fmt.Sscanf(value, "%d", &parsed)
+		return parsed
+	}
+	return 0
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":   nil,
+		"errors": []graphError{{Message: message}},
+	})
+}
@@ -0,0 +1,151 @@
+package fakesauron_test
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"gandalf-data-aggregator/mapper"
+	"gandalf-data-aggregator/models"
+	"gandalf-data-aggregator/webapi/eyeofsauron"
+	"gandalf-data-aggregator/webapi/fakesauron"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/google/uuid"
+)
+
+type appKey struct {
+	private string
+	public  string
+}
+
+func newAppKey(t *testing.T) appKey {
+	t.Helper()
+
+	privateKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	return appKey{
+		private: hex.EncodeToString(privateKey.Serialize()),
+		public:  hex.EncodeToString(privateKey.PubKey().SerializeCompressed()),
+	}
+}
+
+// newClient starts a fake sauron trusting serverKey and returns a client signing with clientKey.
+func newClient(t *testing.T, serverKey, clientKey appKey) (*fakesauron.Server, *eyeofsauron.RateLimitedClient) {
+	t.Helper()
+
+	srv, err := fakesauron.New(serverKey.public)
+	if err != nil {
+		t.Fatalf("unable to start fake sauron: %v", err)
+	}
+	httpServer := httptest.NewServer(srv)
+	t.Cleanup(httpServer.Close)
+
+	client, err := eyeofsauron.New(httpServer.URL, clientKey.private, eyeofsauron.WithHTTPClient(httpServer.Client()))
+	if err != nil {
+		t.Fatalf("unable to build sauron client: %v", err)
+	}
+	return srv, eyeofsauron.NewRateLimitedClient(client, eyeofsauron.RateLimit{RequestsPerSecond: 100, Burst: 10})
+}
+
+func TestSignedRequestIsAccepted(t *testing.T) {
+	key := newAppKey(t)
+	_, client := newClient(t, key, key)
+
+	resp, err := client.GetActivity(context.Background(), "data-key", eyeofsauron.SourceNetflix, 2, 1)
+	if err != nil {
+		t.Fatalf("GetActivity returned error: %v", err)
+	}
+
+	activity := resp.GetActivity
+	if activity.Total != 3 || len(activity.Data) != 2 || activity.Page != 1 {
+		t.Errorf("got total %d, page %d with %d activities, want total 3, page 1 with 2 activities", activity.Total, activity.Page, len(activity.Data))
+	}
+}
+
+func TestForgedSignatureIsRejected(t *testing.T) {
+	_, client := newClient(t, newAppKey(t), newAppKey(t))
+
+	_, err := client.GetActivity(context.Background(), "data-key", eyeofsauron.SourceNetflix, 10, 1)
+	if !errors.Is(err, eyeofsauron.ErrPermanent) {
+		t.Fatalf("GetActivity error = %v, want a permanent error", err)
+	}
+	// a signature failure is the app's fault, it must not deactivate the user's data key
+	if errors.Is(err, eyeofsauron.ErrDataKeyRejected) {
+		t.Fatalf("GetActivity error = %v, want it not to reject the data key", err)
+	}
+}
+
+func TestUnsignedRequestIsRejected(t *testing.T) {
+	srv, err := fakesauron.New(newAppKey(t).public)
+	if err != nil {
+		t.Fatalf("unable to start fake sauron: %v", err)
+	}
+
+	body := `{"query":"query getActivity { getActivity { total } }","variables":{}}`
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/public/gql", strings.NewReader(body)))
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestUnknownDataKeyIsRejected(t *testing.T) {
+	key := newAppKey(t)
+	srv, client := newClient(t, key, key)
+	srv.AllowDataKeys("known-key")
+
+	if err := client.VerifyDataKey(context.Background(), "known-key", eyeofsauron.SourceNetflix); err != nil {
+		t.Fatalf("VerifyDataKey of an allowed key returned error: %v", err)
+	}
+
+	err := client.VerifyDataKey(context.Background(), "unknown-key", eyeofsauron.SourceNetflix)
+	if !errors.Is(err, eyeofsauron.ErrDataKeyRejected) {
+		t.Fatalf("VerifyDataKey error = %v, want ErrDataKeyRejected", err)
+	}
+}
+
+func TestFixturesMapBySource(t *testing.T) {
+	key := newAppKey(t)
+	_, client := newClient(t, key, key)
+	registry := mapper.NewDefaultRegistry()
+	userID := uuid.New()
+
+	for _, dataType := range models.DataTypes {
+		t.Run(string(dataType), func(t *testing.T) {
+			sourceMapper, err := registry.LookupDataType(dataType)
+			if err != nil {
+				t.Fatalf("no mapper for %s: %v", dataType, err)
+			}
+
+			resp, err := client.GetActivity(context.Background(), "data-key", sourceMapper.Source(), 100, 1)
+			if err != nil {
+				t.Fatalf("GetActivity returned error: %v", err)
+			}
+
+			activities := resp.GetActivity.Data
+			if len(activities) == 0 {
+				t.Fatalf("fixture for %s has no activities", sourceMapper.Source())
+			}
+
+			for _, activity := range activities {
+				mapped, err := sourceMapper.Map(userID, activity.Id, activity.GetMetadata())
+				if err != nil {
+					t.Fatalf("unable to map activity %s: %v", activity.Id, err)
+				}
+				if mapped.DataType != dataType || mapped.ProviderActivityID != activity.Id || mapped.UserID != userID {
+					t.Errorf("mapped activity %s as %s/%s for %s", activity.Id, mapped.DataType, mapped.ProviderActivityID, mapped.UserID)
+				}
+				if mapped.Date.IsZero() {
+					t.Errorf("mapped activity %s has no date", activity.Id)
+				}
+			}
+		})
+	}
+}
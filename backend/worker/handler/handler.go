@@ -3,9 +3,12 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gandalf-data-aggregator/config"
+	"gandalf-data-aggregator/models"
 	"gandalf-data-aggregator/service"
+	"gandalf-data-aggregator/webapi/eyeofsauron"
 	workertask "gandalf-data-aggregator/worker/tasks"
 
 	"github.com/go-redis/redis"
@@ -58,7 +61,29 @@ func (t JobHandler) GenerateActivityStats(ctx context.Context, task *asynq.Task)
 		return fmt.Errorf("failed to unmarshal task payload: %w", err)
 	}
 
-	err := t.service.GenerateActivityStats(ctx, payload.UserID)
+	// payloads queued before sources were tracked only ever carried netflix keys
+	if payload.DataType == "" {
+		payload.DataType = models.DataTypeNetflix
+	}
+
+	run, err := t.service.StartSyncRun(ctx, payload.UserID, payload.DataType, models.SyncRunKindStats)
+	if errors.Is(err, service.ErrSourceNotLinked) {
+		log.Info().Msgf("Skipped stats of %s for user %s, the source was unlinked", payload.DataType, payload.UserID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to start sync run: %w", err)
+	}
+
+	processed, err := t.service.GenerateActivityStats(ctx, payload.UserID, payload.DataType)
+	run.ActivitiesProcessed = processed
+	if err == nil {
+		_, err = t.service.RefreshNetflixTitles(ctx, payload.UserID)
+	}
+	if err == nil {
+		err = t.service.RefreshViewingHabits(ctx, payload.UserID)
+	}
+	t.service.FinishSyncRun(ctx, run, err)
 	if err != nil {
 		return fmt.Errorf("unable to generate activitity stats: %w", err)
 	}
@@ -66,18 +91,103 @@ func (t JobHandler) GenerateActivityStats(ctx context.Context, task *asynq.Task)
 	return nil
 }
 
+func (t JobHandler) RebuildActivityStats(ctx context.Context, task *asynq.Task) error {
+	var payload workertask.QueuePayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal task payload: %w", err)
+	}
+
+	counted, err := t.service.RebuildActivityStats(ctx, payload.UserID)
+	if err != nil {
+		return fmt.Errorf("unable to rebuild activity stats: %w", err)
+	}
+
+	if _, err := t.service.RefreshNetflixTitles(ctx, payload.UserID); err != nil {
+		return fmt.Errorf("unable to parse netflix titles: %w", err)
+	}
+
+	if err := t.service.RefreshViewingHabits(ctx, payload.UserID); err != nil {
+		return fmt.Errorf("unable to refresh viewing habits: %w", err)
+	}
+
+	log.Info().Msgf("Rebuilt stats of user %s from %d activities", payload.UserID, counted)
+	return nil
+}
+
 func (t JobHandler) ResolveUserActivityData(ctx context.Context, task *asynq.Task) error {
 	var payload workertask.QueuePayload
 	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
 		return fmt.Errorf("failed to unmarshal task payload: %w", err)
 	}
 
-	err := t.service.FetchAndDumpUserActivities(ctx, payload.UserID, payload.DataKey)
+	// payloads queued before sources were tracked only ever carried netflix keys
+	if payload.DataType == "" {
+		payload.DataType = models.DataTypeNetflix
+	}
+
+	run, err := t.service.StartSyncRun(ctx, payload.UserID, payload.DataType, models.SyncRunKindImport)
+	if errors.Is(err, service.ErrSourceNotLinked) {
+		log.Info().Msgf("Skipped import of %s for user %s, the source was unlinked", payload.DataType, payload.UserID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to start sync run: %w", err)
+	}
+
+	result, err := t.service.FetchAndDumpUserActivities(ctx, payload.UserID, payload.DataType)
+	if errors.Is(err, service.ErrSourceNotLinked) {
+		// the run was purged with the source, finishing it would only report a failure the user cannot act on
+		log.Info().Msgf("Stopped import of %s for user %s, the source was unlinked", payload.DataType, payload.UserID)
+		return nil
+	}
+	if result != nil {
+		run.PagesFetched = result.Pages
+		run.ActivitiesInserted = result.Inserted
+		run.DuplicatesSkipped = result.Duplicates
+		run.ParseFailures = result.ParseFailures
+	}
+	t.service.FinishSyncRun(ctx, run, err)
 	if err != nil {
-		return fmt.Errorf("unable to fetch and dump user activities: %w", err)
+		return retryable(fmt.Errorf("unable to fetch and dump user activities: %w", err))
 	}
 
 	_ = t.wt.EnqueueGenerateActivityStats(payload)
 
 	return nil
 }
+
+func (t JobHandler) ResolveUserTraits(ctx context.Context, task *asynq.Task) error {
+	var payload workertask.QueuePayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal task payload: %w", err)
+	}
+
+	err := t.service.FetchAndDumpUserTraits(ctx, payload.UserID, payload.DataType)
+	if errors.Is(err, service.ErrSourceNotLinked) {
+		log.Info().Msgf("Stopped traits import of %s for user %s, the source was unlinked", payload.DataType, payload.UserID)
+		return nil
+	}
+	if err != nil {
+		return retryable(fmt.Errorf("unable to fetch and dump user traits: %w", err))
+	}
+
+	return nil
+}
+
+func (t JobHandler) ResyncStaleDataKeys(ctx context.Context, task *asynq.Task) error {
+	enqueued, err := t.service.EnqueueStaleDataKeySyncs(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to enqueue stale data key syncs: %w", err)
+	}
+
+	log.Info().Msgf("Enqueued %d stale data key syncs", enqueued)
+	return nil
+}
+
+// retryable stops asynq from retrying errors gandalf will keep returning, such as an invalid data key.
+func retryable(err error) error {
+	if errors.Is(err, eyeofsauron.ErrPermanent) {
+		return fmt.Errorf("%w: %w", err, asynq.SkipRetry)
+	}
+	return err
+}
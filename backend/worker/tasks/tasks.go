@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"gandalf-data-aggregator/config"
+	"gandalf-data-aggregator/models"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -16,11 +17,15 @@ import (
 const (
 	TypeActivityDataResolver  = "resolver:data"
 	TypeGenerateActivityStats = "generate:stats"
+	TypeTraitsResolver        = "resolver:traits"
+	TypeResyncStaleDataKeys   = "scheduler:resync"
+	TypeRebuildActivityStats  = "rebuild:stats"
 )
 
 type WorkerTask struct {
-	client *asynq.Client
-	cfg    config.Config
+	client    *asynq.Client
+	inspector *asynq.Inspector
+	cfg       config.Config
 }
 
 func NewWorkerTask(cfg config.Config) WorkerTask {
@@ -29,24 +34,26 @@ func NewWorkerTask(cfg config.Config) WorkerTask {
 		log.Fatal().Err(err).Msg("unable to parse redis URL")
 	}
 
-	client := asynq.NewClient(asynq.RedisClientOpt{
+	redisOpts := asynq.RedisClientOpt{
 		Addr:     opt.Addr,
 		Password: opt.Password,
 		DB:       opt.DB,
-	})
+	}
 
 	return WorkerTask{
-		cfg:    cfg,
-		client: client,
+		cfg:       cfg,
+		client:    asynq.NewClient(redisOpts),
+		inspector: asynq.NewInspector(redisOpts),
 	}
 }
 
 type QueuePayload struct {
-	UserID  uuid.UUID
-	DataKey string
+	UserID   uuid.UUID
+	DataType models.DataType
 }
 
-func (t WorkerTask) EnqueueActivityDataResolver(queuePayload QueuePayload) error {
+// EnqueueActivityDataResolver queues an import, opts are applied after the defaults so callers can delay or dedupe it.
+func (t WorkerTask) EnqueueActivityDataResolver(queuePayload QueuePayload, opts ...asynq.Option) error {
 	payload, err := json.Marshal(queuePayload)
 	if err != nil {
 		return fmt.Errorf("unable to marsahl page %w", err)
@@ -55,8 +62,10 @@ func (t WorkerTask) EnqueueActivityDataResolver(queuePayload QueuePayload) error
 	newTask := asynq.NewTask(
 		TypeActivityDataResolver,
 		payload,
-		asynq.MaxRetry(2),
-		asynq.Retention(1*time.Hour),
+		append([]asynq.Option{
+			asynq.MaxRetry(2),
+			asynq.Retention(1 * time.Hour),
+		}, opts...)...,
 	)
 
 	_, err = t.client.Enqueue(newTask)
@@ -70,6 +79,8 @@ func (t WorkerTask) EnqueueActivityDataResolver(queuePayload QueuePayload) error
 	return nil
 }
 
+// EnqueueGenerateActivityStats queues a stats pass over the payload's source, it is dropped while a pass of the same
+// user and source is still queued.
 func (t WorkerTask) EnqueueGenerateActivityStats(queuePayload QueuePayload) error {
 	payload, err := json.Marshal(queuePayload)
 	if err != nil {
@@ -95,3 +106,139 @@ func (t WorkerTask) EnqueueGenerateActivityStats(queuePayload QueuePayload) erro
 
 	return nil
 }
+
+func (t WorkerTask) EnqueueTraitsResolver(queuePayload QueuePayload, opts ...asynq.Option) error {
+	payload, err := json.Marshal(queuePayload)
+	if err != nil {
+		return fmt.Errorf("unable to marsahl page %w", err)
+	}
+
+	newTask := asynq.NewTask(
+		TypeTraitsResolver,
+		payload,
+		append([]asynq.Option{
+			asynq.Unique(1 * time.Hour),
+			asynq.MaxRetry(3),
+			asynq.Retention(1 * time.Hour),
+		}, opts...)...,
+	)
+
+	_, err = t.client.Enqueue(newTask)
+	if err != nil && errors.Is(err, asynq.ErrDuplicateTask) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not enqueue task: %v", err)
+	}
+
+	return nil
+}
+
+// EnqueueRebuildActivityStats queues a full recount of a user's stats, only the UserID of the payload is used.
+func (t WorkerTask) EnqueueRebuildActivityStats(queuePayload QueuePayload) error {
+	payload, err := json.Marshal(queuePayload)
+	if err != nil {
+		return fmt.Errorf("unable to marsahl page %w", err)
+	}
+
+	newTask := asynq.NewTask(
+		TypeRebuildActivityStats,
+		payload,
+		asynq.Unique(10*time.Minute),
+		asynq.MaxRetry(3),
+		asynq.Retention(1*time.Hour),
+	)
+
+	_, err = t.client.Enqueue(newTask)
+	if err != nil && errors.Is(err, asynq.ErrDuplicateTask) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not enqueue task: %v", err)
+	}
+
+	return nil
+}
+
+// NewResyncStaleDataKeysTask is registered with the scheduler to periodically refresh stale data keys.
+func NewResyncStaleDataKeysTask() *asynq.Task {
+	return asynq.NewTask(TypeResyncStaleDataKeys, nil, asynq.MaxRetry(0))
+}
+
+const inspectPageSize = 100
+
+// CancelUserTasks removes the pending, scheduled and retrying tasks queued for a user's source and asks
+// workers to stop the ones in progress. It returns how many tasks were cancelled.
+func (t WorkerTask) CancelUserTasks(userID uuid.UUID, dataType models.DataType) (int, error) {
+	queues, err := t.inspector.Queues()
+	if err != nil {
+		return 0, fmt.Errorf("unable to list queues: %w", err)
+	}
+
+	matches := func(task *asynq.TaskInfo) bool {
+		var payload QueuePayload
+		if err := json.Unmarshal(task.Payload, &payload); err != nil {
+			return false
+		}
+		return payload.UserID == userID && payload.DataType == dataType
+	}
+
+	cancelled := 0
+	for _, queue := range queues {
+		listers := []func(string, ...asynq.ListOption) ([]*asynq.TaskInfo, error){
+			t.inspector.ListPendingTasks,
+			t.inspector.ListScheduledTasks,
+			t.inspector.ListRetryTasks,
+		}
+
+		for _, list := range listers {
+			tasks, err := listAll(queue, list)
+			if err != nil {
+				return cancelled, err
+			}
+
+			for _, task := range tasks {
+				if !matches(task) {
+					continue
+				}
+				if err := t.inspector.DeleteTask(queue, task.ID); err != nil && !errors.Is(err, asynq.ErrTaskNotFound) {
+					return cancelled, fmt.Errorf("unable to delete task %s: %w", task.ID, err)
+				}
+				cancelled++
+			}
+		}
+
+		active, err := listAll(queue, t.inspector.ListActiveTasks)
+		if err != nil {
+			return cancelled, err
+		}
+		for _, task := range active {
+			if !matches(task) {
+				continue
+			}
+			if err := t.inspector.CancelProcessing(task.ID); err != nil {
+				return cancelled, fmt.Errorf("unable to cancel task %s: %w", task.ID, err)
+			}
+			cancelled++
+		}
+	}
+
+	return cancelled, nil
+}
+
+// listAll collects every page of a task listing, deleting while paging would skip tasks.
+func listAll(queue string, list func(string, ...asynq.ListOption) ([]*asynq.TaskInfo, error)) ([]*asynq.TaskInfo, error) {
+	var tasks []*asynq.TaskInfo
+	for page := 1; ; page++ {
+		batch, err := list(queue, asynq.PageSize(inspectPageSize), asynq.Page(page))
+		if err != nil {
+			return nil, fmt.Errorf("unable to list tasks in %s: %w", queue, err)
+		}
+		tasks = append(tasks, batch...)
+		if len(batch) < inspectPageSize {
+			return tasks, nil
+		}
+	}
+}
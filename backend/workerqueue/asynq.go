@@ -66,6 +66,16 @@ func (srv AsynqServer) Run() error {
 	return srv.AsynqSrv.Run(srv.AsynqSrvMux)
 }
 
+func (srv AsynqServer) RegisterPeriodicTask(cronspec string, task *asynq.Task, opts ...asynq.Option) error {
+	entryID, err := srv.AsynqScheduler.Register(cronspec, task, opts...)
+	if err != nil {
+		return fmt.Errorf("unable to register periodic task %s: %w", task.Type(), err)
+	}
+
+	log.Info().Msgf("Registered periodic task %s (%s) as %s", task.Type(), cronspec, entryID)
+	return nil
+}
+
 func (srv AsynqServer) DeleteArchivedTasks(queueName string) error {
 	log.Info().Msgf("Delete archived tasks for %s", queueName)
 